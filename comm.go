@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+const (
+	vmCommTarget       = "whitenote.vm"         // target name of the VM inspector
+	vmCommInterval     = 100 * time.Millisecond // interval of the state updates while running
+	vmCommPollInterval = 10 * time.Millisecond  // interval of polling the shell while running
+)
+
+// commClient sends the comm messages to the frontend.
+type commClient interface {
+	commMsg(parent *Message, id string, data map[string]any)
+	commClose(parent *Message, id string, data map[string]any)
+}
+
+// comms is the VM inspector comms of the whitenote.vm target.
+// The frontend receives the state of the VM, and controls the execution
+// by the "pause", "step" and "continue" methods.
+type comms struct {
+	client commClient
+
+	mu       sync.Mutex
+	ids      map[string]bool // opened comm ids
+	running  bool
+	paused   bool
+	notified bool // the paused state is sent
+	steps    int  // opcodes to be run before pausing
+	last     time.Time
+}
+
+func newComms(client commClient) *comms {
+	return &comms{
+		client: client,
+		ids:    make(map[string]bool),
+	}
+}
+
+// open opens the comm of the target and sends the current state.
+// The comm of an unknown target is closed.
+// vm must not be running in the other goroutine.
+func (c *comms) open(parent *Message, id, target string, vm *wspace.VM) {
+	if target != vmCommTarget {
+		c.client.commClose(parent, id, map[string]any{"error": "unknown target: " + target})
+		return
+	}
+	c.mu.Lock()
+	c.ids[id] = true
+	c.mu.Unlock()
+	c.client.commMsg(parent, id, c.state(vm))
+}
+
+// close closes the comm. The paused execution is continued when no comm is left.
+func (c *comms) close(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ids, id)
+	if len(c.ids) == 0 {
+		c.paused = false
+		c.steps = 0
+	}
+}
+
+// reset forgets the opened comms without closing them.
+func (c *comms) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ids = make(map[string]bool)
+	c.paused = false
+	c.steps = 0
+}
+
+// info returns the comms of the target for comm_info_reply.
+func (c *comms) info(target string) map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info := make(map[string]any)
+	if target != "" && target != vmCommTarget {
+		return info
+	}
+	for id := range c.ids {
+		info[id] = map[string]any{"target_name": vmCommTarget}
+	}
+	return info
+}
+
+// active reports whether any comm is opened.
+func (c *comms) active() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.ids) > 0
+}
+
+// message handles the method sent from the frontend.
+// vm must not be running in the other goroutine.
+func (c *comms) message(parent *Message, id string, data map[string]any, vm *wspace.VM) {
+	c.mu.Lock()
+	if !c.ids[id] {
+		c.mu.Unlock()
+		return
+	}
+	// pause, continue and step are ignored while no cell is running.
+	switch data["method"] {
+	case "pause":
+		if c.running && !c.paused {
+			c.paused = true
+			c.notified = false
+		}
+	case "continue":
+		if c.running {
+			c.paused = false
+			c.steps = 0
+		}
+	case "step":
+		if c.running {
+			c.paused = false
+			c.steps = 1
+		}
+	case "state":
+	default:
+		c.mu.Unlock()
+		c.client.commMsg(parent, id, map[string]any{"event": "error", "message": "unknown method: " + toString(data["method"])})
+		return
+	}
+	c.mu.Unlock()
+
+	// the paused state is sent by beforeStep.
+	if data["method"] == "state" {
+		c.client.commMsg(parent, id, c.state(vm))
+	}
+}
+
+func toString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// start is called when a cell starts running.
+func (c *comms) start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = true
+	c.last = time.Time{}
+}
+
+// finish is called when the cell finished and sends the last state.
+func (c *comms) finish(parent *Message, vm *wspace.VM) {
+	c.mu.Lock()
+	c.running = false
+	c.paused = false
+	c.steps = 0
+	c.mu.Unlock()
+	c.broadcast(parent, vm)
+}
+
+// beforeStep is called before each opcode while a cell is running.
+// It sends the state at the interval or when paused, and reports whether the execution must wait.
+func (c *comms) beforeStep(parent *Message, vm *wspace.VM) bool {
+	c.mu.Lock()
+	if c.paused {
+		notify := !c.notified
+		c.notified = true
+		c.mu.Unlock()
+		if notify {
+			c.broadcast(parent, vm)
+		}
+		return true
+	}
+	if c.steps > 0 {
+		c.steps--
+		if c.steps == 0 {
+			c.paused = true
+			c.notified = false
+		}
+	}
+	notify := time.Since(c.last) >= vmCommInterval
+	if notify {
+		c.last = time.Now()
+	}
+	c.mu.Unlock()
+	if notify {
+		c.broadcast(parent, vm)
+	}
+	return false
+}
+
+func (c *comms) broadcast(parent *Message, vm *wspace.VM) {
+	c.mu.Lock()
+	ids := make([]string, 0, len(c.ids))
+	for id := range c.ids {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+	st := c.state(vm)
+	for _, id := range ids {
+		c.client.commMsg(parent, id, st)
+	}
+}
+
+// state returns the state of the VM as the comm data.
+func (c *comms) state(vm *wspace.VM) map[string]any {
+	c.mu.Lock()
+	running, paused := c.running, c.paused
+	c.mu.Unlock()
+
+	heap := make(map[string]int, len(vm.Heap))
+	for a, v := range vm.Heap {
+		heap[strconv.Itoa(a)] = v
+	}
+	callstack := make([]string, 0, len(vm.CallStack))
+	for _, pc := range vm.CallStack {
+		callstack = append(callstack, programPos(vm, pc))
+	}
+	op := ""
+	if o := vm.CurrentOpCode(); o != nil && running {
+		op = o.Cmd.String()
+		if o.Param != nil {
+			op += " " + opParam(o)
+		}
+	}
+	return map[string]any{
+		"event":     "state",
+		"running":   running,
+		"paused":    paused,
+		"pc":        programPos(vm, vm.PC),
+		"op":        op,
+		"stack":     append([]int{}, vm.Stack...),
+		"heap":      heap,
+		"callstack": callstack,
+	}
+}
+
+// runInspected runs the VM like vm.Run, sending the state to the comms.
+// The comm messages on the shell are handled between the opcodes and the others are deferred.
+// vmMu must be locked.
+func (s *Sockets) runInspected(ctx context.Context, vm *wspace.VM, parent *Message, in wspace.InputReader, out *streamWriter) error {
+	s.comms.start()
+	defer s.comms.finish(parent, vm)
+
+	var polled time.Time
+	for !vm.Terminated {
+		select {
+		case <-ctx.Done():
+			return wspace.ErrContextDone
+		default:
+		}
+		if time.Since(polled) >= vmCommPollInterval {
+			polled = time.Now()
+			if err := s.pumpShell(ctx, vm, 0); err != nil {
+				return err
+			}
+		}
+		for s.comms.beforeStep(parent, vm) {
+			out.Flush()
+			if err := s.pumpShell(ctx, vm, 100*time.Millisecond); err != nil {
+				return err
+			}
+		}
+		if err := vm.Step(in, out); err != nil {
+			if err == wspace.ErrNotLoaded {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// handleComm handles the comm messages on the shell.
+func (s *Sockets) handleComm(vm *wspace.VM, msg *Message, msgtype string) {
+	var content struct {
+		CommID     string         `json:"comm_id"`
+		TargetName string         `json:"target_name"`
+		Data       map[string]any `json:"data"`
+	}
+	_ = json.Unmarshal(msg.Content, &content)
+
+	switch msgtype {
+	case "comm_open":
+		s.comms.open(msg, content.CommID, content.TargetName, vm)
+	case "comm_msg":
+		s.comms.message(msg, content.CommID, content.Data, vm)
+	case "comm_close":
+		s.comms.close(content.CommID)
+	case "comm_info_request":
+		rep, _ := json.Marshal(map[string]any{
+			"status": "ok",
+			"comms":  s.comms.info(content.TargetName),
+		})
+		s.sendRouter(s.shell, msg, "comm_info_reply", rep)
+	}
+}
+
+func (s *Sockets) commMsg(parent *Message, id string, data map[string]any) {
+	content, _ := json.Marshal(map[string]any{
+		"comm_id": id,
+		"data":    data,
+	})
+	s.send(s.iopub, parent, "comm_msg", content)
+}
+
+func (s *Sockets) commClose(parent *Message, id string, data map[string]any) {
+	content, _ := json.Marshal(map[string]any{
+		"comm_id": id,
+		"data":    data,
+	})
+	s.send(s.iopub, parent, "comm_close", content)
+}
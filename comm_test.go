@@ -0,0 +1,199 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+type commRecord struct {
+	id    string
+	close bool
+	data  map[string]any
+}
+
+// fakeCommClient records the comm messages sent to the frontend.
+type fakeCommClient struct {
+	msgs []commRecord
+}
+
+func (c *fakeCommClient) commMsg(parent *Message, id string, data map[string]any) {
+	c.msgs = append(c.msgs, commRecord{id, false, data})
+}
+
+func (c *fakeCommClient) commClose(parent *Message, id string, data map[string]any) {
+	c.msgs = append(c.msgs, commRecord{id, true, data})
+}
+
+func (c *fakeCommClient) pop(t *testing.T) commRecord {
+	t.Helper()
+	if len(c.msgs) == 0 {
+		t.Fatalf("no message")
+	}
+	m := c.msgs[0]
+	c.msgs = c.msgs[1:]
+	return m
+}
+
+func TestCommOpen(t *testing.T) {
+	client := &fakeCommClient{}
+	c := newComms(client)
+	vm := wspace.New()
+	vm.Stack = []int{1, 2}
+
+	c.open(nil, "x", "unknown", vm)
+	if m := client.pop(t); !m.close || m.id != "x" {
+		t.Fatalf("unknown target: %v", m)
+	}
+	if c.active() {
+		t.Fatalf("active after opening an unknown target")
+	}
+
+	c.open(nil, "a", vmCommTarget, vm)
+	m := client.pop(t)
+	if m.close || m.id != "a" || m.data["event"] != "state" || m.data["running"] != false {
+		t.Fatalf("open: %v", m)
+	}
+	if st := m.data["stack"].([]int); len(st) != 2 || st[1] != 2 {
+		t.Fatalf("stack: %v", st)
+	}
+
+	if info := c.info(""); len(info) != 1 || info["a"] == nil {
+		t.Fatalf("info: %v", info)
+	}
+	if info := c.info("other"); len(info) != 0 {
+		t.Fatalf("info of other target: %v", info)
+	}
+
+	c.message(nil, "a", map[string]any{"method": "state"}, vm)
+	if m := client.pop(t); m.data["event"] != "state" {
+		t.Fatalf("state: %v", m)
+	}
+	c.message(nil, "a", map[string]any{"method": "bad"}, vm)
+	if m := client.pop(t); m.data["event"] != "error" {
+		t.Fatalf("bad method: %v", m)
+	}
+
+	c.close("a")
+	if c.active() {
+		t.Fatalf("active after close")
+	}
+}
+
+func TestCommStep(t *testing.T) {
+	client := &fakeCommClient{}
+	c := newComms(client)
+	vm := wspace.New()
+	// push 1; push 2; add; end
+	if _, _, err := vm.Load([]byte("   \t\n   \t \n\t   \n\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	c.open(nil, "a", vmCommTarget, vm)
+	client.pop(t)
+
+	// step and continue are ignored before the cell runs.
+	for _, method := range []string{"step", "continue"} {
+		c.message(nil, "a", map[string]any{"method": method}, vm)
+		if c.steps != 0 || c.paused {
+			t.Fatalf("%v before running: steps=%v paused=%v", method, c.steps, c.paused)
+		}
+	}
+
+	c.start()
+	c.message(nil, "a", map[string]any{"method": "pause"}, vm)
+
+	// paused before the first opcode: the state is sent once.
+	for i := 0; i < 3; i++ {
+		if !c.beforeStep(nil, vm) {
+			t.Fatalf("not paused")
+		}
+	}
+	if m := client.pop(t); m.data["paused"] != true || m.data["op"] != "Push 1" {
+		t.Fatalf("paused: %v", m)
+	}
+	if len(client.msgs) != 0 {
+		t.Fatalf("extra messages: %v", client.msgs)
+	}
+
+	// step runs an opcode and pauses again.
+	c.message(nil, "a", map[string]any{"method": "step"}, vm)
+	if c.beforeStep(nil, vm) {
+		t.Fatalf("paused after step")
+	}
+	client.msgs = nil
+	if err := vm.Step(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !c.beforeStep(nil, vm) {
+		t.Fatalf("not paused after an opcode")
+	}
+	if m := client.pop(t); m.data["pc"] != "1:5" || m.data["op"] != "Push 2" {
+		t.Fatalf("stepped: %v", m)
+	}
+
+	// continue runs to the end.
+	c.message(nil, "a", map[string]any{"method": "continue"}, vm)
+	for !vm.Terminated {
+		if c.beforeStep(nil, vm) {
+			t.Fatalf("paused after continue")
+		}
+		if err := vm.Step(nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.msgs = nil
+	c.finish(nil, vm)
+	m := client.pop(t)
+	if m.data["running"] != false || m.data["paused"] != false {
+		t.Fatalf("finish: %v", m)
+	}
+	if st := m.data["stack"].([]int); len(st) != 1 || st[0] != 3 {
+		t.Fatalf("stack: %v", st)
+	}
+}
+
+// vmState receives the iopub messages until the VM state which satisfies f.
+func (k *testKernel) vmState(f func(data map[string]any) bool) map[string]any {
+	k.t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		m := k.recv(k.iopub)
+		if m.msgType != "comm_msg" {
+			continue
+		}
+		if d, _ := m.content["data"].(map[string]any); d["event"] == "state" && f(d) {
+			return d
+		}
+	}
+	k.t.Fatalf("no expected state")
+	return nil
+}
+
+func TestCommPause(t *testing.T) {
+	k := newTestKernel(t)
+	id := k.request(k.shell, "comm_open", map[string]any{"comm_id": "a", "target_name": vmCommTarget, "data": map[string]any{}})
+	k.published(id)
+
+	// push 0; A: push 1; add; jump A
+	id = k.request(k.shell, "execute_request", map[string]any{"code": "   \n\n   \n   \t\n\t   \n \n \n"})
+	k.vmState(func(d map[string]any) bool { return d["running"] == true })
+
+	k.request(k.shell, "comm_msg", map[string]any{"comm_id": "a", "data": map[string]any{"method": "pause"}})
+	paused := k.vmState(func(d map[string]any) bool { return d["paused"] == true })
+
+	k.request(k.shell, "comm_msg", map[string]any{"comm_id": "a", "data": map[string]any{"method": "step"}})
+	k.vmState(func(d map[string]any) bool { return d["paused"] == true && d["pc"] != paused["pc"] })
+
+	k.request(k.shell, "comm_msg", map[string]any{"comm_id": "a", "data": map[string]any{"method": "continue"}})
+	k.vmState(func(d map[string]any) bool { return d["running"] == true && d["paused"] == false })
+
+	k.request(k.control, "interrupt_request", map[string]any{})
+	if rep := k.reply(k.shell, id, "execute_reply"); rep["status"] != "error" || rep["ename"] != "KeyboardInterrupt" {
+		t.Fatalf("execute_reply: %v", rep)
+	}
+	st := k.vmState(func(d map[string]any) bool { return d["running"] == false })
+	if st["paused"] != false {
+		t.Fatalf("finished: %v", st)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+// completion is an item of the completion.
+// The fields are the same as the items of "_jupyter_types_experimental" in the metadata.
+type completion struct {
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+	Text      string `json:"text"`
+	Type      string `json:"type"`
+	Signature string `json:"signature"`
+}
+
+func (s *Sockets) sendCompleteReply(sock socket, req *Message, vm *wspace.VM) {
+	var content struct {
+		Code      string `json:"code"`
+		CursorPos int    `json:"cursor_pos"`
+	}
+	_ = json.Unmarshal(req.Content, &content)
+	pos := content.CursorPos
+
+	_, code := parseMagics([]byte(content.Code[:bytePos(content.Code, pos)]))
+	items := s.completions(vm, code)
+	if len(items) == 0 {
+		// Tab key inserts a tab.
+		items = []completion{{Text: "\t", Type: "tab"}}
+	}
+
+	matches := make([]string, len(items))
+	for i := range items {
+		items[i].Start = pos
+		items[i].End = pos
+		matches[i] = items[i].Text
+	}
+	rep, _ := json.Marshal(map[string]any{
+		"status":       "ok",
+		"matches":      matches,
+		"cursor_start": pos,
+		"cursor_end":   pos,
+		"metadata": map[string]any{
+			"_jupyter_types_experimental": items,
+		},
+	})
+	s.sendRouter(sock, req, "complete_reply", rep)
+}
+
+// completions returns the continuations of the incomplete instruction at the end of the code.
+func (s *Sockets) completions(vm *wspace.VM, code []byte) []completion {
+	cell := s.scratchVM(vm, false)
+	_, pos, err := cell.Load(code)
+	if !errors.Is(err, wspace.ErrIncompleteCode) {
+		return nil
+	}
+	partial := whitespaces(code[pos:])
+
+	var items []completion
+	for cmd := wspace.Push; cmd <= wspace.Trace; cmd++ {
+		if !available(cell, cmd) {
+			continue
+		}
+		c := cmd.Code()
+		if len(partial) < len(c) && strings.HasPrefix(c, partial) {
+			items = append(items, completion{
+				Text:      c[len(partial):],
+				Type:      cmd.String(),
+				Signature: fmt.Sprintf("%s  %s", wspace.Visualize(c), cmd.StackEffect()),
+			})
+			continue
+		}
+
+		switch cmd {
+		case wspace.Call, wspace.Jump, wspace.JZero, wspace.JNeg:
+			if !strings.HasPrefix(partial, c) {
+				continue
+			}
+			l := partial[len(c):]
+			if strings.Contains(l, "\n") {
+				continue
+			}
+			for _, name := range s.labelNames(vm, cell) {
+				if strings.HasPrefix(name, l) {
+					items = append(items, completion{
+						Text:      name[len(l):] + "\n",
+						Type:      "label",
+						Signature: fmt.Sprintf("%v %s", cmd, wspace.LabelName(name)),
+					})
+				}
+			}
+		}
+	}
+	return items
+}
+
+// labelNames returns the sorted labels defined in the VM and the cell.
+func (s *Sockets) labelNames(vm, cell *wspace.VM) []string {
+	s.vmMu.Lock()
+	defer s.vmMu.Unlock()
+
+	names := make([]string, 0, len(vm.Labels))
+	for l := range vm.Labels {
+		names = append(names, l)
+	}
+	for l := range cell.Labels {
+		if _, exists := vm.Labels[l]; !exists {
+			names = append(names, l)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// available reports whether the command is available on the dialect and the extensions of the VM.
+func available(vm *wspace.VM, cmd wspace.Command) bool {
+	switch cmd {
+	case wspace.Copy, wspace.Slide:
+		return vm.Dialect != wspace.Whitespace02
+	case wspace.DebugStack:
+		return vm.Extensions&wspace.ExtDebugStack != 0
+	case wspace.DebugHeap:
+		return vm.Extensions&wspace.ExtDebugHeap != 0
+	case wspace.Trace:
+		return vm.Extensions&wspace.ExtTrace != 0
+	}
+	return true
+}
+
+func whitespaces(code []byte) string {
+	ws := make([]byte, 0, len(code))
+	for _, c := range code {
+		if c == ' ' || c == '\t' || c == '\n' {
+			ws = append(ws, c)
+		}
+	}
+	return string(ws)
+}
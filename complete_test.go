@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+func TestCompletions(t *testing.T) {
+	tests := map[string]struct {
+		code    string
+		dialect wspace.Dialect
+		ext     wspace.Extension
+		expect  []string // type and text
+	}{
+		"empty":    {"", 0, 0, nil},
+		"complete": {"   \t\n", 0, 0, nil},
+		"invalid":  {"\t\n\n", 0, 0, nil},
+		"stack": {" ", 0, 0, []string{
+			"Push", " ", "Dup", "\n ", "Copy", "\t ", "Swap", "\n\t", "Discard", "\n\n", "Slide", "\t\n",
+		}},
+		"stack 0.2": {" ", wspace.Whitespace02, 0, []string{
+			"Push", " ", "Dup", "\n ", "Swap", "\n\t", "Discard", "\n\n",
+		}},
+		"io": {"   \t\n\t\n", 0, 0, []string{
+			"WriteChar", "  ", "WriteNum", " \t", "ReadChar", "\t ", "ReadNum", "\t\t",
+		}},
+		"comment": {"push1:   \t\nout:\tx\n", 0, 0, []string{
+			"WriteChar", "  ", "WriteNum", " \t", "ReadChar", "\t ", "ReadNum", "\t\t",
+		}},
+		"end": {"\n\n", 0, 0, []string{"End", "\n"}},
+		"extensions": {"\n\n", 0, wspace.ExtAll, []string{
+			"End", "\n", "DebugStack", "  ", "DebugHeap", " \t", "Trace", "\t ",
+		}},
+		"labels": {"\n  \t\t\n\n \n", 0, 0, []string{
+			"label", "\t\t\n", "label", " \t\n",
+		}},
+		"label prefix": {"\n  \t\t\n\n \n\t", 0, 0, []string{"label", "\t\n"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &Sockets{}
+			vm := newVM()
+			vm.Dialect = test.dialect
+			vm.Extensions = test.ext
+			vm.Labels[" \t"] = 0
+
+			var items []string
+			for _, c := range s.completions(vm, []byte(test.code)) {
+				items = append(items, c.Type, c.Text)
+			}
+			if !reflect.DeepEqual(items, test.expect) {
+				t.Fatalf("completions: %q, wants %q", items, test.expect)
+			}
+		})
+	}
+}
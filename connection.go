@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type ConnectionInfo struct {
+	SignatureScheme string `json:"signature_scheme"`
+	Transport       string `json:"transport"`
+	StdinPort       int    `json:"stdin_port"`
+	ControlPort     int    `json:"control_port"`
+	IOPubPort       int    `json:"iopub_port"`
+	HBPort          int    `json:"hb_port"`
+	ShellPort       int    `json:"shell_port"`
+	Key             string `json:"key"`
+	IP              string `json:"ip"`
+}
+
+// readConf reads and validates the connection file.
+func readConf(file string) (*ConnectionInfo, error) {
+	c, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("connection file: %w", err)
+	}
+	var conf ConnectionInfo
+	if err := json.Unmarshal(c, &conf); err != nil {
+		return nil, fmt.Errorf("connection file %s: %w", file, err)
+	}
+	if err := conf.validate(); err != nil {
+		return nil, fmt.Errorf("connection file %s: %w", file, err)
+	}
+	return &conf, nil
+}
+
+// validate checks the connection info. The transport defaults to tcp.
+func (c *ConnectionInfo) validate() error {
+	switch c.Transport {
+	case "":
+		c.Transport = "tcp"
+	case "tcp", "ipc":
+	default:
+		return fmt.Errorf("unsupported transport %q: tcp or ipc is expected", c.Transport)
+	}
+	if c.IP == "" {
+		if c.Transport == "ipc" {
+			return errors.New("ip (the path prefix of the ipc endpoints) is empty")
+		}
+		return errors.New("ip is empty")
+	}
+	if _, err := newSigner(c.SignatureScheme, c.Key); err != nil {
+		return err
+	}
+	for _, p := range c.ports() {
+		if *p.port < 0 || (c.Transport == "tcp" && *p.port > 65535) {
+			return fmt.Errorf("invalid %s: %d", p.name, *p.port)
+		}
+	}
+	return nil
+}
+
+type portField struct {
+	name string
+	port *int
+}
+
+// ports returns the ports in the order of the binding.
+func (c *ConnectionInfo) ports() []portField {
+	return []portField{
+		{"shell_port", &c.ShellPort},
+		{"control_port", &c.ControlPort},
+		{"stdin_port", &c.StdinPort},
+		{"iopub_port", &c.IOPubPort},
+		{"hb_port", &c.HBPort},
+	}
+}
+
+// hasUnassignedPort reports whether any port is left for the kernel to choose.
+func (c *ConnectionInfo) hasUnassignedPort() bool {
+	for _, p := range c.ports() {
+		if *p.port == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// endpoint returns the endpoint of the port.
+// The tcp port 0 is the port chosen by the system, and
+// the ipc endpoint is the path of "<ip>-<port>" as jupyter_client does.
+func (c *ConnectionInfo) endpoint(port int) string {
+	if c.Transport == "ipc" {
+		return fmt.Sprintf("ipc://%s-%d", c.IP, port)
+	}
+	if port == 0 {
+		return fmt.Sprintf("tcp://%s:*", c.IP)
+	}
+	return fmt.Sprintf("tcp://%s:%d", c.IP, port)
+}
+
+// assignIPCPorts assigns the unused numbers to the ipc ports of 0.
+func (c *ConnectionInfo) assignIPCPorts() {
+	used := make(map[int]bool)
+	for _, p := range c.ports() {
+		used[*p.port] = true
+	}
+	n := 1
+	for _, p := range c.ports() {
+		if *p.port != 0 {
+			continue
+		}
+		for ; used[n] || exists(fmt.Sprintf("%s-%d", c.IP, n)); n++ {
+		}
+		*p.port = n
+		used[n] = true
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// boundPort returns the port of the bound tcp endpoint.
+func boundPort(endpoint string) (int, error) {
+	i := strings.LastIndexByte(endpoint, ':')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid endpoint: %q", endpoint)
+	}
+	return strconv.Atoi(endpoint[i+1:])
+}
+
+// writePorts writes the ports back to the connection file,
+// keeping the other fields of the file.
+func writePorts(file string, conf *ConnectionInfo) error {
+	c, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(c, &fields); err != nil {
+		return err
+	}
+	for _, p := range conf.ports() {
+		fields[p.name] = *p.port
+	}
+	c, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(c, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConf(t *testing.T, conf string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "kernel.json")
+	if err := os.WriteFile(file, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadConf(t *testing.T) {
+	tests := map[string]string{
+		`{"ip":`:                               "unexpected end of JSON input",
+		`{"transport":"udp","ip":"127.0.0.1"}`: `unsupported transport "udp"`,
+		`{"transport":"tcp"}`:                  "ip is empty",
+		`{"transport":"ipc"}`:                  "ip (the path prefix of the ipc endpoints) is empty",
+		`{"ip":"127.0.0.1","signature_scheme":"hmac-sha1"}`:             `unsupported signature scheme "hmac-sha1"`,
+		`{"ip":"127.0.0.1","shell_port":-1}`:                            "invalid shell_port: -1",
+		`{"ip":"127.0.0.1","hb_port":65536}`:                            "invalid hb_port: 65536",
+		`{"ip":"127.0.0.1","stdin_port":"1"}`:                           "cannot unmarshal string",
+		`{"transport":"ipc","ip":"kernel","iopub_port":65536,"key":""}`: "",
+	}
+	for conf, want := range tests {
+		_, err := readConf(writeConf(t, conf))
+		if want == "" {
+			if err != nil {
+				t.Errorf("%s: %v", conf, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v, wants %q", conf, err, want)
+		}
+	}
+
+	if _, err := readConf(filepath.Join(t.TempDir(), "none.json")); err == nil {
+		t.Errorf("no error for the missing file")
+	}
+
+	conf, err := readConf(writeConf(t, `{"ip":"127.0.0.1","shell_port":1234}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Transport != "tcp" || conf.endpoint(conf.ShellPort) != "tcp://127.0.0.1:1234" || conf.endpoint(0) != "tcp://127.0.0.1:*" {
+		t.Errorf("tcp: %v %v %v", conf.Transport, conf.endpoint(conf.ShellPort), conf.endpoint(0))
+	}
+}
+
+func TestAssignPorts(t *testing.T) {
+	file := writeConf(t, `{"ip":"127.0.0.1","key":"k","shell_port":50100,"kernel_name":"whitenote"}`)
+	conf, err := readConf(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conf.hasUnassignedPort() {
+		t.Fatalf("no unassigned port")
+	}
+	if _, err := newSockets(conf, newMemTransport()); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePorts(file, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(file)
+	var fields map[string]any
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+	wants := map[string]any{
+		"shell_port":   float64(50100),
+		"control_port": float64(50001),
+		"stdin_port":   float64(50002),
+		"iopub_port":   float64(50003),
+		"hb_port":      float64(50004),
+		"key":          "k",
+		"kernel_name":  "whitenote",
+	}
+	for k, v := range wants {
+		if fields[k] != v {
+			t.Errorf("%s: %v, wants %v", k, fields[k], v)
+		}
+	}
+}
+
+func TestAssignIPCPorts(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "kernel")
+	// the existing path is skipped.
+	if err := os.WriteFile(prefix+"-2", nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf := &ConnectionInfo{Transport: "ipc", IP: prefix, IOPubPort: 3}
+	mt := newMemTransport()
+	s, err := newSockets(conf, mt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ShellPort != 1 || conf.ControlPort != 4 || conf.StdinPort != 5 || conf.IOPubPort != 3 || conf.HBPort != 6 {
+		t.Fatalf("ports: %+v", conf)
+	}
+	if ep := conf.endpoint(conf.ShellPort); ep != "ipc://"+prefix+"-1" {
+		t.Fatalf("endpoint: %v", ep)
+	}
+	if mt.socks["ipc://"+prefix+"-6"] != s.hb {
+		t.Fatalf("hb is not bound")
+	}
+}
+
+func TestBindError(t *testing.T) {
+	conf := &ConnectionInfo{Transport: "tcp", IP: "127.0.0.1", ShellPort: 1, ControlPort: 2, StdinPort: 1}
+	_, err := newSockets(conf, newMemTransport())
+	if err == nil || !strings.HasPrefix(err.Error(), "stdin_port: bind tcp://127.0.0.1:1: ") {
+		t.Fatalf("error: %v", err)
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+	"github.com/makiuchi-d/whitenote/wspace/debug"
+)
+
+// the cell source paths are calculated by the frontend in the same way as the kernel:
+// tmpFilePrefix + murmur2(code, hashSeed) + tmpFileSuffix
+const (
+	hashSeed      = 0xc70f6907
+	tmpFileSuffix = ".ws"
+)
+
+var tmpFilePrefix = filepath.Join(os.TempDir(), fmt.Sprintf("whitenote_%d", os.Getpid())) + string(filepath.Separator)
+
+// debugRequest is a request of the Debug Adapter Protocol.
+type debugRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path"`
+}
+
+// debugger runs the VM step by step with the breakpoints.
+// While the execution is stopped, the VM is accessed from the control handler.
+type debugger struct {
+	socks *Sockets
+	sess  *debug.Session
+
+	mu          sync.Mutex
+	seq         int
+	started     bool
+	breakpoints map[string]map[int]bool // source path -> lines
+	sources     map[string][]byte       // source path -> code (dumped cells)
+
+	// execution state
+	cells  map[int]cellSource // copy of socks.sources taken under vmMu
+	parent *Message           // execute_request
+	prev   lineKey            // line of the previous opcode
+	lines  map[[2]int]lineKey
+}
+
+type lineKey struct {
+	path string
+	line int
+}
+
+func newDebugger(socks *Sockets) *debugger {
+	d := &debugger{
+		socks:       socks,
+		breakpoints: make(map[string]map[int]bool),
+		sources:     make(map[string][]byte),
+	}
+	d.sess = debug.NewSession(d)
+	return d
+}
+
+// sourcePath returns the path of the cell source which the frontend knows.
+func sourcePath(src []byte) string {
+	return tmpFilePrefix + strconv.FormatUint(uint64(murmur2(src, hashSeed)), 10) + tmpFileSuffix
+}
+
+// murmur2 is the 32-bit MurmurHash2 used by the JupyterLab debugger.
+func murmur2(data []byte, seed uint32) uint32 {
+	const m = 0x5bd1e995
+	h := seed ^ uint32(len(data))
+	for ; len(data) >= 4; data = data[4:] {
+		k := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h = h*m ^ k
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// reset stops the debugger and forgets the breakpoints and the dumped cells.
+func (d *debugger) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq = 0
+	d.started = false
+	d.breakpoints = make(map[string]map[int]bool)
+	d.sources = make(map[string][]byte)
+}
+
+// active reports whether the frontend has started the debugger.
+func (d *debugger) active() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.started
+}
+
+// run runs the VM like vm.Run, stopping at the breakpoints and the steps.
+// vmMu must be locked.
+func (d *debugger) run(ctx context.Context, vm *wspace.VM, parent *Message, in wspace.InputReader, out io.Writer) error {
+	cells := make(map[int]cellSource, len(d.socks.sources))
+	for seg, c := range d.socks.sources {
+		cells[seg] = c
+	}
+
+	d.mu.Lock()
+	d.cells = cells
+	d.parent = parent
+	d.prev = lineKey{}
+	d.lines = make(map[[2]int]lineKey)
+	d.mu.Unlock()
+
+	return d.sess.Run(ctx, vm, in, out)
+}
+
+// Breakpoint implements debug.Frontend.
+// The execution stops when it enters a line with a breakpoint.
+func (d *debugger) Breakpoint(vm *wspace.VM, pc int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := d.lineOf(&vm.Program[pc])
+	entered := key != d.prev
+	d.prev = key
+	return entered && d.started && d.breakpoints[key.path][key.line]
+}
+
+// lineOf returns the source line of the opcode. d.mu must be locked.
+func (d *debugger) lineOf(op *wspace.OpCode) lineKey {
+	k := [2]int{op.Seg, op.Pos}
+	if l, ok := d.lines[k]; ok {
+		return l
+	}
+	var l lineKey
+	if c, ok := d.cells[op.Seg]; ok && c.off+op.Pos <= len(c.src) {
+		l = lineKey{c.path, lineNum(c.src, c.off+op.Pos)}
+	}
+	d.lines[k] = l
+	return l
+}
+
+// Locate implements debug.Frontend.
+func (d *debugger) Locate(vm *wspace.VM, pc int) (debug.Location, bool) {
+	op := &vm.Program[pc]
+	d.mu.Lock()
+	c, ok := d.cells[op.Seg]
+	d.mu.Unlock()
+	if !ok || c.off+op.Pos > len(c.src) {
+		return debug.Location{}, false
+	}
+	p := c.off + op.Pos
+	start := 0
+	for i := p - 1; i >= 0; i-- {
+		if c.src[i] == '\n' {
+			start = i + 1
+			break
+		}
+	}
+	return debug.Location{
+		Name:   fmt.Sprintf("segment %v", op.Seg),
+		Path:   c.path,
+		Line:   lineNum(c.src, p),
+		Column: utf8.RuneCount(c.src[start:p]) + 1,
+	}, true
+}
+
+// Stopped implements debug.Frontend.
+func (d *debugger) Stopped(reason string) {
+	d.mu.Lock()
+	parent := d.parent
+	d.mu.Unlock()
+	d.sendEvent(parent, "stopped", map[string]any{
+		"reason":            reason,
+		"threadId":          debug.ThreadID,
+		"allThreadsStopped": true,
+	})
+}
+
+// handle handles the debug_request on the control channel.
+func (d *debugger) handle(vm *wspace.VM, msg *Message) {
+	var req debugRequest
+	if err := json.Unmarshal(msg.Content, &req); err != nil {
+		d.sendResponse(msg, &req, fmt.Errorf("invalid request: %w", err), nil)
+		return
+	}
+
+	var body any
+	var err error
+	switch req.Command {
+	case "initialize":
+		body = map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsSteppingGranularity":      false,
+		}
+		d.sendResponse(msg, &req, nil, body)
+		d.sendEvent(msg, "initialized", nil)
+		return
+	case "attach":
+		d.mu.Lock()
+		d.started = true
+		d.mu.Unlock()
+	case "configurationDone":
+	case "disconnect":
+		d.mu.Lock()
+		d.started = false
+		d.breakpoints = make(map[string]map[int]bool)
+		d.mu.Unlock()
+		d.sess.Continue(debug.StepNone)
+	case "debugInfo":
+		body = d.debugInfo()
+	case "dumpCell":
+		body, err = d.dumpCell(req.Arguments)
+	case "source":
+		body, err = d.source(req.Arguments)
+	case "setBreakpoints":
+		body, err = d.setBreakpoints(req.Arguments)
+	case "threads":
+		body = map[string]any{
+			"threads": []map[string]any{{"id": debug.ThreadID, "name": "main"}},
+		}
+	case "continue":
+		if err = d.sess.Continue(debug.StepNone); err == nil {
+			body = map[string]any{"allThreadsContinued": true}
+		}
+	case "next":
+		err = d.sess.Continue(debug.StepOver)
+	case "stepIn":
+		err = d.sess.Continue(debug.StepIn)
+	case "stepOut":
+		err = d.sess.Continue(debug.StepOut)
+	case "pause":
+		d.sess.Pause()
+	case "stackTrace":
+		body = d.stackTrace()
+	case "scopes":
+		body = debug.Scopes()
+	case "variables":
+		body, err = d.variables(vm, req.Arguments)
+	case "inspectVariables":
+		body = d.inspectVariables(vm)
+	default:
+		err = fmt.Errorf("unsupported command: %v", req.Command)
+	}
+	d.sendResponse(msg, &req, err, body)
+}
+
+func (d *debugger) nextSeq() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	return d.seq
+}
+
+func (d *debugger) sendResponse(parent *Message, req *debugRequest, err error, body any) {
+	rep := map[string]any{
+		"seq":         d.nextSeq(),
+		"type":        "response",
+		"request_seq": req.Seq,
+		"command":     req.Command,
+		"success":     err == nil,
+	}
+	if err != nil {
+		rep["message"] = err.Error()
+	}
+	if body != nil {
+		rep["body"] = body
+	}
+	content, _ := json.Marshal(rep)
+	d.socks.sendRouter(d.socks.control, parent, "debug_reply", content)
+}
+
+func (d *debugger) sendEvent(parent *Message, event string, body any) {
+	ev := map[string]any{
+		"seq":   d.nextSeq(),
+		"type":  "event",
+		"event": event,
+	}
+	if body != nil {
+		ev["body"] = body
+	}
+	content, _ := json.Marshal(ev)
+	d.socks.send(d.socks.iopub, parent, "debug_event", content)
+}
+
+func (d *debugger) debugInfo() map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	paths := make([]string, 0, len(d.breakpoints))
+	for p := range d.breakpoints {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	bps := make([]map[string]any, 0, len(paths))
+	for _, p := range paths {
+		bps = append(bps, map[string]any{
+			"source":      p,
+			"breakpoints": breakpointList(d.breakpoints[p]),
+		})
+	}
+	stopped := []int{}
+	if d.sess.Stopped() {
+		stopped = append(stopped, debug.ThreadID)
+	}
+	return map[string]any{
+		"isStarted":      d.started,
+		"hashMethod":     "Murmur2",
+		"hashSeed":       hashSeed,
+		"tmpFilePrefix":  tmpFilePrefix,
+		"tmpFileSuffix":  tmpFileSuffix,
+		"breakpoints":    bps,
+		"stoppedThreads": stopped,
+		"richRendering":  false,
+		"exceptionPaths": []string{},
+	}
+}
+
+func breakpointList(lines map[int]bool) []map[string]any {
+	ls := make([]int, 0, len(lines))
+	for l := range lines {
+		ls = append(ls, l)
+	}
+	sort.Ints(ls)
+	bps := make([]map[string]any, 0, len(ls))
+	for _, l := range ls {
+		bps = append(bps, map[string]any{"verified": true, "line": l})
+	}
+	return bps
+}
+
+func (d *debugger) dumpCell(args json.RawMessage) (any, error) {
+	var a struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	src := []byte(a.Code)
+	path := sourcePath(src)
+	d.mu.Lock()
+	d.sources[path] = src
+	d.mu.Unlock()
+	return map[string]any{"sourcePath": path}, nil
+}
+
+func (d *debugger) source(args json.RawMessage) (any, error) {
+	var a struct {
+		Source dapSource `json:"source"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	src, ok := d.sources[a.Source.Path]
+	if !ok {
+		return nil, fmt.Errorf("source not found: %v", a.Source.Path)
+	}
+	return map[string]any{"content": string(src)}, nil
+}
+
+func (d *debugger) setBreakpoints(args json.RawMessage) (any, error) {
+	var a struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	lines := make(map[int]bool, len(a.Breakpoints))
+	for _, b := range a.Breakpoints {
+		lines[b.Line] = true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(lines) == 0 {
+		delete(d.breakpoints, a.Source.Path)
+	} else {
+		d.breakpoints[a.Source.Path] = lines
+	}
+	return map[string]any{"breakpoints": breakpointList(lines)}, nil
+}
+
+// stackTrace returns the stack frames, which are empty unless the execution is stopped.
+func (d *debugger) stackTrace() any {
+	body, err := d.sess.StackTrace()
+	if err != nil {
+		return map[string]any{"stackFrames": []map[string]any{}, "totalFrames": 0}
+	}
+	return body
+}
+
+func (d *debugger) variables(vm *wspace.VM, args json.RawMessage) (any, error) {
+	var a struct {
+		Ref int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	vars := []map[string]any{}
+	var err error
+	d.withVM(vm, func(vm *wspace.VM) {
+		vars, err = d.sess.Variables(vm, a.Ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"variables": vars}, nil
+}
+
+// inspectVariables returns the containers for the variable explorer.
+func (d *debugger) inspectVariables(vm *wspace.VM) any {
+	vars := []map[string]any{}
+	d.withVM(vm, func(vm *wspace.VM) {
+		vars = append(vars,
+			container("stack", fmt.Sprint(vm.Stack), debug.RefStack),
+			container("heap", fmt.Sprintf("%d cells", len(vm.Heap)), debug.RefHeap),
+			container("callstack", fmt.Sprintf("%d frames", len(vm.CallStack)), debug.RefCallStack))
+	})
+	return map[string]any{"variables": vars}
+}
+
+// withVM calls f with the VM if it is stopped or idle.
+func (d *debugger) withVM(vm *wspace.VM, f func(vm *wspace.VM)) {
+	if d.sess.Stopped() {
+		f(vm)
+		return
+	}
+	if d.socks.vmMu.TryLock() {
+		defer d.socks.vmMu.Unlock()
+		f(vm)
+	}
+}
+
+func container(name, value string, ref int) map[string]any {
+	return map[string]any{"name": name, "value": value, "type": "", "variablesReference": ref}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/makiuchi-d/whitenote/wspace/debug"
+)
+
+// debug sends the debug_request and returns its msg_id and the successful response.
+func (k *testKernel) debug(seq int, command string, args any) (string, map[string]any) {
+	k.t.Helper()
+	id := k.request(k.control, "debug_request", map[string]any{
+		"seq":       seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	rep := k.reply(k.control, id, "debug_reply")
+	if rep["success"] != true || rep["command"] != command || rep["request_seq"] != float64(seq) {
+		k.t.Fatalf("debug_reply: %v", rep)
+	}
+	return id, rep
+}
+
+// event receives the iopub messages until the debug_event of the request.
+func (k *testKernel) event(id, event string) map[string]any {
+	k.t.Helper()
+	for {
+		m := k.recv(k.iopub)
+		if m.parent == id && m.msgType == "debug_event" && m.content["event"] == event {
+			return m.content
+		}
+	}
+}
+
+// body returns the field of the response body.
+func body(rep map[string]any, key string) any {
+	b, _ := rep["body"].(map[string]any)
+	return b[key]
+}
+
+func TestDebugger(t *testing.T) {
+	k := newTestKernel(t)
+	// push 1; push 2; add; outnum; end
+	code := "   \t\n   \t \n\t   \t\n \t\n\n\n"
+	path := sourcePath([]byte(code))
+
+	id, _ := k.debug(1, "initialize", map[string]any{"clientID": "test", "adapterID": "whitenote"})
+	k.event(id, "initialized")
+	k.debug(2, "attach", map[string]any{})
+	_, rep := k.debug(3, "setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": path},
+		"breakpoints": []map[string]any{{"line": 2}},
+	})
+	bps, _ := body(rep, "breakpoints").([]any)
+	if len(bps) != 1 || bps[0].(map[string]any)["line"] != float64(2) {
+		t.Fatalf("setBreakpoints: %v", rep)
+	}
+	k.debug(4, "configurationDone", map[string]any{})
+
+	id = k.request(k.shell, "execute_request", map[string]any{"code": code})
+	ev := k.event(id, "stopped")
+	if b := ev["body"].(map[string]any); b["reason"] != "breakpoint" || b["threadId"] != float64(debug.ThreadID) {
+		t.Fatalf("stopped: %v", ev)
+	}
+
+	_, rep = k.debug(5, "stackTrace", map[string]any{"threadId": debug.ThreadID})
+	frames, _ := body(rep, "stackFrames").([]any)
+	if len(frames) != 1 {
+		t.Fatalf("stackTrace: %v", rep)
+	}
+	f := frames[0].(map[string]any)
+	src, _ := f["source"].(map[string]any)
+	if f["name"] != "(main): Push" || f["line"] != float64(2) || f["column"] != float64(1) || src["path"] != path {
+		t.Fatalf("stackFrame: %v", f)
+	}
+
+	_, rep = k.debug(6, "variables", map[string]any{"variablesReference": debug.RefStack})
+	vars, _ := body(rep, "variables").([]any)
+	if len(vars) != 1 || vars[0].(map[string]any)["value"] != "1" {
+		t.Fatalf("variables: %v", rep)
+	}
+
+	_, rep = k.debug(7, "continue", map[string]any{"threadId": debug.ThreadID})
+	if body(rep, "allThreadsContinued") != true {
+		t.Fatalf("continue: %v", rep)
+	}
+	if rep := k.reply(k.shell, id, "execute_reply"); rep["status"] != "ok" {
+		t.Fatalf("execute_reply: %v", rep)
+	}
+	if out := stdout(k.published(id)); out != "3" {
+		t.Fatalf("stdout: %q", out)
+	}
+
+	// the steps fail while the execution is not stopped.
+	for i, cmd := range []string{"continue", "next", "stepIn", "stepOut"} {
+		id := k.request(k.control, "debug_request", map[string]any{
+			"seq":       8 + i,
+			"type":      "request",
+			"command":   cmd,
+			"arguments": map[string]any{"threadId": debug.ThreadID},
+		})
+		rep := k.reply(k.control, id, "debug_reply")
+		if rep["success"] != false || rep["message"] != debug.ErrNotStopped.Error() || rep["body"] != nil {
+			t.Fatalf("%v while not stopped: %v", cmd, rep)
+		}
+	}
+}
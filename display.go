@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+// vmState is the state of the VM after the execution.
+type vmState struct {
+	Stack     []int             `json:"stack"`
+	Heap      map[string]int    `json:"heap"` // changed cells only
+	CallStack []string          `json:"callstack"`
+	Labels    map[string]string `json:"labels"`
+}
+
+func newVMState(vm *wspace.VM, heap map[int]int) *vmState {
+	st := &vmState{
+		Stack:     append([]int{}, vm.Stack...),
+		Heap:      make(map[string]int),
+		CallStack: make([]string, 0, len(vm.CallStack)),
+		Labels:    make(map[string]string, len(vm.Labels)),
+	}
+	for a, v := range vm.Heap {
+		if o, ok := heap[a]; !ok || o != v {
+			st.Heap[strconv.Itoa(a)] = v
+		}
+	}
+	for _, pc := range vm.CallStack {
+		st.CallStack = append(st.CallStack, programPos(vm, pc))
+	}
+	for l, p := range vm.Labels {
+		st.Labels[wspace.LabelName(l)] = programPos(vm, p)
+	}
+	return st
+}
+
+// programPos returns the position of the opcode in the form "seg:pos".
+func programPos(vm *wspace.VM, pc int) string {
+	if pc >= len(vm.Program) {
+		return "end"
+	}
+	op := vm.Program[pc]
+	return fmt.Sprintf("%v:%v", op.Seg, op.Pos)
+}
+
+func (st *vmState) data() map[string]any {
+	return map[string]any{
+		"text/plain":       st.text(),
+		"text/html":        st.html(),
+		"application/json": st,
+	}
+}
+
+func (st *vmState) text() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "stack:", st.Stack)
+	fmt.Fprintln(&b, "heap:", st.Heap)
+	fmt.Fprintln(&b, "callstack:", st.CallStack)
+	fmt.Fprintln(&b, "labels:", st.Labels)
+	return b.String()
+}
+
+func (st *vmState) html() string {
+	var b strings.Builder
+	rows := make([][]string, 0, len(st.Stack))
+	for i := len(st.Stack) - 1; i >= 0; i-- {
+		rows = append(rows, []string{strconv.Itoa(len(st.Stack) - 1 - i), strconv.Itoa(st.Stack[i])})
+	}
+	writeTable(&b, "stack", []string{"#", "value"}, rows)
+
+	addrs := make([]int, 0, len(st.Heap))
+	for a := range st.Heap {
+		n, _ := strconv.Atoi(a)
+		addrs = append(addrs, n)
+	}
+	sort.Ints(addrs)
+	rows = make([][]string, 0, len(addrs))
+	for _, a := range addrs {
+		rows = append(rows, []string{strconv.Itoa(a), strconv.Itoa(st.Heap[strconv.Itoa(a)])})
+	}
+	writeTable(&b, "heap (changed)", []string{"address", "value"}, rows)
+
+	rows = make([][]string, 0, len(st.CallStack))
+	for i := len(st.CallStack) - 1; i >= 0; i-- {
+		rows = append(rows, []string{strconv.Itoa(len(st.CallStack) - 1 - i), st.CallStack[i]})
+	}
+	writeTable(&b, "callstack", []string{"#", "return to"}, rows)
+
+	names := make([]string, 0, len(st.Labels))
+	for l := range st.Labels {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+	rows = make([][]string, 0, len(names))
+	for _, l := range names {
+		rows = append(rows, []string{l, st.Labels[l]})
+	}
+	writeTable(&b, "labels", []string{"label", "defined at"}, rows)
+
+	return b.String()
+}
+
+func writeTable(b *strings.Builder, caption string, head []string, rows [][]string) {
+	fmt.Fprintf(b, `<table style="display:inline-table;vertical-align:top;margin-right:1em">`)
+	fmt.Fprintf(b, "<caption>%s</caption><tr>", caption)
+	for _, h := range head {
+		fmt.Fprintf(b, "<th>%s</th>", h)
+	}
+	b.WriteString("</tr>")
+	for _, r := range rows {
+		b.WriteString("<tr>")
+		for _, c := range r {
+			fmt.Fprintf(b, "<td>%s</td>", html.EscapeString(c))
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</table>")
+}
+
+func (s *Sockets) sendExecuteResult(parent *Message, count int, st *vmState) {
+	content, _ := json.Marshal(map[string]any{
+		"execution_count": count,
+		"data":            st.data(),
+		"metadata":        map[string]any{},
+	})
+	s.send(s.iopub, parent, "execute_result", content)
+}
+
+func (s *Sockets) sendDisplayData(parent *Message, data map[string]any) {
+	content, _ := json.Marshal(map[string]any{
+		"data":      data,
+		"metadata":  map[string]any{},
+		"transient": map[string]any{},
+	})
+	s.send(s.iopub, parent, "display_data", content)
+}
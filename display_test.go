@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+func TestVMState(t *testing.T) {
+	prog := []wspace.OpCode{
+		{Cmd: wspace.Mark, Param: " \t", Seg: 1, Pos: 0},
+		{Cmd: wspace.Ret, Seg: 1, Pos: 5},
+		{Cmd: wspace.Call, Param: " \t", Seg: 2, Pos: 3},
+		{Cmd: wspace.End, Seg: 2, Pos: 9},
+	}
+	tests := map[string]struct {
+		stack     []int
+		heap      map[int]int
+		prev      map[int]int // heap before the execution
+		callstack []int
+		labels    map[string]int
+		json      string
+		text      string
+		html      []string // in this order
+	}{
+		"empty": {
+			json: `{"stack":[],"heap":{},"callstack":[],"labels":{}}`,
+			text: "stack: []\nheap: map[]\ncallstack: []\nlabels: map[]\n",
+			html: []string{"<caption>stack</caption>", "<caption>heap (changed)</caption>", "<caption>callstack</caption>", "<caption>labels</caption>"},
+		},
+		"stack": {
+			stack: []int{1, 2, 3},
+			json:  `{"stack":[1,2,3],"heap":{},"callstack":[],"labels":{}}`,
+			text:  "stack: [1 2 3]\nheap: map[]\ncallstack: []\nlabels: map[]\n",
+			html:  []string{"<tr><td>0</td><td>3</td></tr>", "<tr><td>2</td><td>1</td></tr>"},
+		},
+		"heap changed": {
+			heap: map[int]int{1: 10, 2: 20, 10: 5},
+			prev: map[int]int{1: 10, 2: 2},
+			json: `{"stack":[],"heap":{"10":5,"2":20},"callstack":[],"labels":{}}`,
+			text: "stack: []\nheap: map[10:5 2:20]\ncallstack: []\nlabels: map[]\n",
+			html: []string{"<tr><td>2</td><td>20</td></tr>", "<tr><td>10</td><td>5</td></tr>"},
+		},
+		"callstack": {
+			callstack: []int{3, 4},
+			json:      `{"stack":[],"heap":{},"callstack":["2:9","end"],"labels":{}}`,
+			text:      "stack: []\nheap: map[]\ncallstack: [2:9 end]\nlabels: map[]\n",
+			html:      []string{"<tr><td>0</td><td>end</td></tr>", "<tr><td>1</td><td>2:9</td></tr>"},
+		},
+		"labels": {
+			labels: map[string]int{" \t": 1, "": 3},
+			json:   `{"stack":[],"heap":{},"callstack":[],"labels":{"(empty)":"2:9","._":"1:5"}}`,
+			text:   "stack: []\nheap: map[]\ncallstack: []\nlabels: map[(empty):2:9 ._:1:5]\n",
+			html:   []string{"<tr><td>(empty)</td><td>2:9</td></tr>", "<tr><td>._</td><td>1:5</td></tr>"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			vm := wspace.New()
+			vm.Program = prog
+			if test.stack != nil {
+				vm.Stack = test.stack
+			}
+			if test.heap != nil {
+				vm.Heap = test.heap
+			}
+			if test.callstack != nil {
+				vm.CallStack = test.callstack
+			}
+			if test.labels != nil {
+				vm.Labels = test.labels
+			}
+			data := newVMState(vm, test.prev).data()
+
+			j, err := json.Marshal(data["application/json"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(j) != test.json {
+				t.Fatalf("json: %s, wants %s", j, test.json)
+			}
+			if data["text/plain"] != test.text {
+				t.Fatalf("text: %q, wants %q", data["text/plain"], test.text)
+			}
+			html := data["text/html"].(string)
+			pos := 0
+			for _, h := range test.html {
+				i := strings.Index(html[pos:], h)
+				if i < 0 {
+					t.Fatalf("html: %q not found after %v in %s", h, pos, html)
+				}
+				pos += i + len(h)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// historyEntry is an executed cell stored in the history.
+type historyEntry struct {
+	Session int    `json:"session"`
+	Line    int    `json:"line"` // execution count
+	Input   string `json:"input"`
+}
+
+// history keeps the executed cells in memory, and in the JSON-lines file if given.
+type history struct {
+	mu      sync.Mutex
+	session int
+	entries []historyEntry
+	file    *os.File
+}
+
+// newHistory loads the history file and starts a new session.
+// The session number is the next of the last one in the file, so the kernels
+// sharing the file at the same time would store the cells in the same session.
+func newHistory(file string) (*history, error) {
+	h := &history{session: 1}
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<24)
+	for sc.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue // skip broken lines
+		}
+		h.entries = append(h.entries, e)
+		if e.Session >= h.session {
+			h.session = e.Session + 1
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	h.file = f
+	return h, nil
+}
+
+// add stores the cell of the current session.
+func (h *history) add(line int, input string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := historyEntry{Session: h.session, Line: line, Input: input}
+	h.entries = append(h.entries, e)
+	if h.file != nil {
+		b, _ := json.Marshal(e)
+		if _, err := h.file.Write(append(b, '\n')); err != nil {
+			slog.Warn("history: stop writing the file", "file", h.file.Name(), "err", err)
+			h.file.Close()
+			h.file = nil
+		}
+	}
+}
+
+// newSession starts a new session on restart.
+func (h *history) newSession() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.session++
+}
+
+func (h *history) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+}
+
+// historyRequest is the content of history_request.
+type historyRequest struct {
+	Output         bool   `json:"output"`
+	Raw            bool   `json:"raw"`
+	HistAccessType string `json:"hist_access_type"`
+	Session        int    `json:"session"`
+	Start          int    `json:"start"`
+	Stop           int    `json:"stop"`
+	N              int    `json:"n"`
+	Pattern        string `json:"pattern"`
+	Unique         bool   `json:"unique"`
+}
+
+// query returns the entries for the request.
+func (h *history) query(req *historyRequest) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var es []historyEntry
+	switch req.HistAccessType {
+	case "range":
+		// the session is relative to the current one if it is not positive.
+		session := req.Session
+		if session <= 0 {
+			session += h.session
+		}
+		for _, e := range h.entries {
+			if e.Session == session && e.Line >= req.Start && (req.Stop <= 0 || e.Line < req.Stop) {
+				es = append(es, e)
+			}
+		}
+	case "tail":
+		es = lastN(h.entries, req.N)
+	case "search":
+		re := globRegexp(req.Pattern)
+		seen := make(map[string]bool)
+		// search from the newest to keep the latest of the duplicated inputs.
+		for i := len(h.entries) - 1; i >= 0; i-- {
+			e := h.entries[i]
+			if !re.MatchString(e.Input) || (req.Unique && seen[e.Input]) {
+				continue
+			}
+			seen[e.Input] = true
+			es = append(es, e)
+		}
+		for i, j := 0, len(es)-1; i < j; i, j = i+1, j-1 {
+			es[i], es[j] = es[j], es[i]
+		}
+		es = lastN(es, req.N)
+	}
+	return es
+}
+
+func lastN(es []historyEntry, n int) []historyEntry {
+	if n > 0 && len(es) > n {
+		return es[len(es)-n:]
+	}
+	return es
+}
+
+// globRegexp converts the glob pattern of the search to the regexp.
+// "*" matches any string and "?" matches any character.
+func globRegexp(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		pattern = "*"
+	}
+	q := regexp.QuoteMeta(pattern)
+	q = strings.NewReplacer(`\*`, `.*`, `\?`, `.`).Replace(q)
+	return regexp.MustCompile(`(?s)^` + q + `$`)
+}
+
+func (s *Sockets) sendHistoryReply(sock socket, req *Message) {
+	hreq := &historyRequest{}
+	_ = json.Unmarshal(req.Content, hreq)
+
+	hist := []any{}
+	for _, e := range s.history.query(hreq) {
+		if hreq.Output {
+			// the outputs are not stored.
+			hist = append(hist, []any{e.Session, e.Line, []any{e.Input, nil}})
+		} else {
+			hist = append(hist, []any{e.Session, e.Line, e.Input})
+		}
+	}
+	content, _ := json.Marshal(map[string]any{
+		"status":  "ok",
+		"history": hist,
+	})
+	s.sendRouter(sock, req, "history_reply", content)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	h := &history{session: 1}
+	h.add(1, "push 1")
+	h.add(2, "push 2")
+	h.add(3, "push 1")
+	h.newSession()
+	h.add(1, "end")
+	h.add(2, "push 1")
+	h.add(3, "a.b")
+	h.add(4, "push 1\nend")
+
+	all := []historyEntry{
+		{1, 1, "push 1"}, {1, 2, "push 2"}, {1, 3, "push 1"},
+		{2, 1, "end"}, {2, 2, "push 1"}, {2, 3, "a.b"}, {2, 4, "push 1\nend"},
+	}
+	tests := map[string]struct {
+		req    historyRequest
+		expect []historyEntry
+	}{
+		"range current":  {historyRequest{HistAccessType: "range"}, all[3:]},
+		"range previous": {historyRequest{HistAccessType: "range", Session: -1}, all[:3]},
+		"range session":  {historyRequest{HistAccessType: "range", Session: 1, Start: 2}, all[1:3]},
+		"range stop":     {historyRequest{HistAccessType: "range", Session: 2, Start: 2, Stop: 4}, all[4:6]},
+		"range none":     {historyRequest{HistAccessType: "range", Session: 5}, nil},
+
+		"tail":     {historyRequest{HistAccessType: "tail", N: 2}, all[5:]},
+		"tail all": {historyRequest{HistAccessType: "tail"}, all},
+		"tail n":   {historyRequest{HistAccessType: "tail", N: 10}, all},
+
+		"search all":      {historyRequest{HistAccessType: "search"}, all},
+		"search star":     {historyRequest{HistAccessType: "search", Pattern: "push*"}, []historyEntry{all[0], all[1], all[2], all[4], all[6]}},
+		"search question": {historyRequest{HistAccessType: "search", Pattern: "push ?"}, []historyEntry{all[0], all[1], all[2], all[4]}},
+		"search literal":  {historyRequest{HistAccessType: "search", Pattern: "a.b"}, all[5:6]},
+		"search meta":     {historyRequest{HistAccessType: "search", Pattern: "a?b"}, all[5:6]},
+		"search anchored": {historyRequest{HistAccessType: "search", Pattern: "push"}, nil},
+		"search newline":  {historyRequest{HistAccessType: "search", Pattern: "push*end"}, all[6:]},
+		"search unique":   {historyRequest{HistAccessType: "search", Pattern: "push ?", Unique: true}, []historyEntry{all[1], all[4]}},
+		"search n":        {historyRequest{HistAccessType: "search", Pattern: "push ?", N: 2}, []historyEntry{all[2], all[4]}},
+		"search unique n": {historyRequest{HistAccessType: "search", Pattern: "*", Unique: true, N: 3}, all[4:]},
+		"unknown":         {historyRequest{HistAccessType: "unknown"}, nil},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if es := h.query(&test.req); !reflect.DeepEqual(es, test.expect) {
+				t.Fatalf("%v, wants %v", es, test.expect)
+			}
+		})
+	}
+}
+
+func TestHistoryWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(&buf, slog.LevelInfo))
+
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := newHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.file.Close() // makes the write fail
+
+	h.add(1, "push 1")
+	h.add(2, "push 2")
+	if h.file != nil {
+		t.Fatalf("file is kept after the write error")
+	}
+	if log := buf.String(); strings.Count(log, "history: stop writing the file") != 1 || !strings.Contains(log, file) {
+		t.Fatalf("log: %s", log)
+	}
+	if es := h.query(&historyRequest{HistAccessType: "tail"}); len(es) != 2 {
+		t.Fatalf("entries: %v", es)
+	}
+}
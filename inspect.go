@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+type labelDef struct {
+	name  string
+	where string
+}
+
+func (s *Sockets) sendInspectReply(sock socket, req *Message, vm *wspace.VM) {
+	var content struct {
+		Code      string `json:"code"`
+		CursorPos int    `json:"cursor_pos"`
+	}
+	_ = json.Unmarshal(req.Content, &content)
+
+	src := []byte(content.Code)
+	_, code := parseMagics(src)
+	// the cursor is in bytes from the beginning of the code after the magics.
+	pos := bytePos(content.Code, content.CursorPos) - (len(src) - len(code))
+	cell := s.scratchVM(vm, false)
+	_, end, _ := cell.Load(code)
+
+	rep := map[string]any{
+		"status":   "ok",
+		"found":    false,
+		"data":     map[string]string{},
+		"metadata": map[string]any{},
+	}
+	if op := opAt(cell.Program, end, pos); op != nil {
+		labels := s.labelTable(vm, cell, code)
+		rep["found"] = true
+		rep["data"] = map[string]string{
+			"text/plain":    inspectText(op, labels),
+			"text/markdown": inspectMarkdown(op, labels),
+		}
+	}
+	c, _ := json.Marshal(rep)
+	s.sendRouter(sock, req, "inspect_reply", c)
+}
+
+// labelTable returns the labels defined in the VM and the cell.
+func (s *Sockets) labelTable(vm, cell *wspace.VM, code []byte) []labelDef {
+	s.vmMu.Lock()
+	defer s.vmMu.Unlock()
+
+	defs := make([]labelDef, 0, len(vm.Labels))
+	for l, p := range vm.Labels {
+		op := vm.Program[p]
+		defs = append(defs, labelDef{wspace.LabelName(l), fmt.Sprintf("%v:%v", op.Seg, op.Pos)})
+	}
+	for _, op := range cell.Program {
+		if op.Cmd == wspace.Mark {
+			defs = append(defs, labelDef{wspace.LabelName(op.Param.(string)), fmt.Sprintf("line %v", lineNum(code, op.Pos))})
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].name < defs[j].name
+	})
+	return defs
+}
+
+// opAt returns the opcode at the position of the code.
+func opAt(prog []wspace.OpCode, end, pos int) *wspace.OpCode {
+	for i := len(prog) - 1; i >= 0; i-- {
+		if prog[i].Pos <= pos {
+			if i == len(prog)-1 && pos > end {
+				return nil
+			}
+			return &prog[i]
+		}
+	}
+	return nil
+}
+
+// bytePos converts the position in unicode characters to the position in bytes.
+func bytePos(code string, pos int) int {
+	b := 0
+	for i := 0; i < pos && b < len(code); i++ {
+		_, n := utf8.DecodeRuneInString(code[b:])
+		b += n
+	}
+	return b
+}
+
+func opParam(op *wspace.OpCode) string {
+	switch p := op.Param.(type) {
+	case int:
+		return fmt.Sprint(p)
+	case string:
+		return wspace.LabelName(p)
+	}
+	return ""
+}
+
+func inspectText(op *wspace.OpCode, labels []labelDef) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, op.Cmd, opParam(op))
+	fmt.Fprintln(&b, "stack:", op.Cmd.StackEffect())
+	fmt.Fprintln(&b, "labels:")
+	for _, l := range labels {
+		fmt.Fprintf(&b, "  %s\t%s\n", l.name, l.where)
+	}
+	return b.String()
+}
+
+func inspectMarkdown(op *wspace.OpCode, labels []labelDef) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%v** %s\n\n", op.Cmd, opParam(op))
+	fmt.Fprintf(&b, "stack: `%s`\n\n", op.Cmd.StackEffect())
+	if len(labels) > 0 {
+		fmt.Fprintln(&b, "| label | defined at |")
+		fmt.Fprintln(&b, "|:--|:--|")
+		for _, l := range labels {
+			fmt.Fprintf(&b, "| `%s` | %s |\n", l.name, l.where)
+		}
+	}
+	return b.String()
+}
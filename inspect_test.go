@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+func TestOpAt(t *testing.T) {
+	tests := map[string]struct {
+		code   string
+		pos    int
+		expect wspace.Command // 0: no opcode
+	}{
+		"first":           {"   \t\n\t\n \t\n\n\n", 0, wspace.Push},
+		"param":           {"   \t\n\t\n \t\n\n\n", 4, wspace.Push},
+		"second":          {"   \t\n\t\n \t\n\n\n", 5, wspace.WriteNum},
+		"last":            {"   \t\n\t\n \t\n\n\n", 11, wspace.End},
+		"end":             {"   \t\n\t\n \t\n\n\n", 12, wspace.End},
+		"after":           {"   \t\n\t\n \t\n\n\n", 13, 0},
+		"comment":         {"p   \tq\nr\t\n \t", 6, wspace.Push},
+		"comment after":   {"p   \tq\nr\t\n \t", 7, wspace.Push},
+		"comment next":    {"p   \tq\nr\t\n \t", 8, wspace.WriteNum},
+		"before":          {"p   \tq\nr\t\n \t", 0, 0},
+		"incomplete":      {"   \t\n\t\n", 5, wspace.Push},
+		"incomplete rest": {"   \t\n\t\n", 6, 0},
+		"empty":           {"", 0, 0},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			vm := wspace.New()
+			_, end, _ := vm.Load([]byte(test.code))
+			op := opAt(vm.Program, end, test.pos)
+			switch {
+			case test.expect == 0 && op != nil:
+				t.Fatalf("opAt: %v, wants nil", op)
+			case test.expect != 0 && (op == nil || op.Cmd != test.expect):
+				t.Fatalf("opAt: %v, wants %v", op, test.expect)
+			}
+		})
+	}
+}
+
+func TestBytePos(t *testing.T) {
+	tests := map[string]struct {
+		code   string
+		pos    int
+		expect int
+	}{
+		"ascii":     {"abc", 2, 2},
+		"multibyte": {"あいう", 1, 3},
+		"mixed":     {"a  \t", 3, 4},
+		"emoji":     {"😀 ", 1, 4},
+		"end":       {"あい", 2, 6},
+		"over":      {"あい", 5, 6},
+		"empty":     {"", 3, 0},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if b := bytePos(test.code, test.pos); b != test.expect {
+				t.Fatalf("bytePos: %v, wants %v", b, test.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+const testTimeout = 3 * time.Second
+
+// testKernel runs the kernel on memTransport.
+type testKernel struct {
+	t        *testing.T
+	socks    *Sockets
+	vm       *wspace.VM
+	shell    *memClient
+	control  *memClient
+	stdin    *memClient
+	iopub    *memClient
+	hb       *memClient
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// testMessage is a message received by the frontend.
+type testMessage struct {
+	msgType string
+	parent  string // msg_id of the parent
+	content map[string]any
+}
+
+func newTestKernel(t *testing.T) *testKernel {
+	return newSignedKernel(t, "hmac-sha256", "secret")
+}
+
+// newSignedKernel runs the kernel with the signature scheme and the key.
+func newSignedKernel(t *testing.T, scheme, key string) *testKernel {
+	conf := &ConnectionInfo{
+		SignatureScheme: scheme,
+		Transport:       "tcp",
+		IP:              "127.0.0.1",
+		ShellPort:       1,
+		ControlPort:     2,
+		StdinPort:       3,
+		IOPubPort:       4,
+		HBPort:          5,
+		Key:             key,
+	}
+	mt := newMemTransport()
+	socks, err := newSockets(conf, mt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &testKernel{
+		t:        t,
+		socks:    socks,
+		shutdown: make(chan struct{}, 1),
+	}
+	k.shell = mt.connect(conf.endpoint(conf.ShellPort), "client")
+	k.control = mt.connect(conf.endpoint(conf.ControlPort), "client")
+	k.stdin = mt.connect(conf.endpoint(conf.StdinPort), "client")
+	k.iopub = mt.connect(conf.endpoint(conf.IOPubPort), "client")
+	k.hb = mt.connect(conf.endpoint(conf.HBPort), "client")
+
+	vm := newVM()
+	k.vm = vm
+	k.run(func() { k.socks.shellHandler(vm) })
+	k.run(func() { k.socks.controlHandler(vm, k.shutdown) })
+	k.run(k.socks.hbHandler)
+	t.Cleanup(k.close)
+	return k
+}
+
+func (k *testKernel) run(f func()) {
+	k.wg.Add(1)
+	go func() {
+		defer k.wg.Done()
+		f()
+	}()
+}
+
+// close terminates the kernel and waits for the handlers.
+func (k *testKernel) close() {
+	k.socks.close()
+	done := make(chan struct{})
+	go func() {
+		k.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		k.t.Errorf("handlers are not finished")
+	}
+}
+
+// request sends the signed request and returns its msg_id.
+func (k *testKernel) request(c *memClient, msgtype string, content any) string {
+	k.t.Helper()
+	hdr := newHeader(msgtype)
+	var h struct {
+		MsgID string `json:"msg_id"`
+	}
+	_ = json.Unmarshal(hdr, &h)
+	body, err := json.Marshal(content)
+	if err != nil {
+		k.t.Fatal(err)
+	}
+	mac := k.socks.signer.sign(hdr, []byte("{}"), metadata, body)
+	c.send([]byte(delimiter), []byte(mac), hdr, []byte("{}"), metadata, body)
+	return h.MsgID
+}
+
+// recv receives a message and verifies its signature.
+func (k *testKernel) recv(c *memClient) *testMessage {
+	k.t.Helper()
+	mb, ok := c.recv(testTimeout)
+	if !ok {
+		k.t.Fatalf("no message")
+	}
+	if len(mb) < 6 || !bytes.Equal(mb[0], []byte(delimiter)) {
+		k.t.Fatalf("invalid message: %q", mb)
+	}
+	if mac := k.socks.signer.sign(mb[2], mb[3], mb[4], mb[5]); string(mb[1]) != mac {
+		k.t.Fatalf("invalid signature: %q", mb)
+	}
+	var hdr, phdr struct {
+		MsgID   string `json:"msg_id"`
+		MsgType string `json:"msg_type"`
+	}
+	if err := json.Unmarshal(mb[2], &hdr); err != nil {
+		k.t.Fatalf("header: %v", err)
+	}
+	_ = json.Unmarshal(mb[3], &phdr)
+	m := &testMessage{msgType: hdr.MsgType, parent: phdr.MsgID}
+	if err := json.Unmarshal(mb[5], &m.content); err != nil {
+		k.t.Fatalf("content: %v", err)
+	}
+	return m
+}
+
+// reply receives the reply of the request.
+func (k *testKernel) reply(c *memClient, id, msgtype string) map[string]any {
+	k.t.Helper()
+	m := k.recv(c)
+	if m.msgType != msgtype || m.parent != id {
+		k.t.Fatalf("reply: %v (parent=%v), wants %v (parent=%v)", m.msgType, m.parent, msgtype, id)
+	}
+	return m.content
+}
+
+// published receives the iopub messages of the request until the kernel becomes idle.
+func (k *testKernel) published(id string) []*testMessage {
+	k.t.Helper()
+	var ms []*testMessage
+	for {
+		m := k.recv(k.iopub)
+		if m.parent != id {
+			continue
+		}
+		if m.msgType == "status" && m.content["execution_state"] == "idle" {
+			return ms
+		}
+		ms = append(ms, m)
+	}
+}
+
+// stdout returns the text of the stdout streams.
+func stdout(ms []*testMessage) string {
+	var s string
+	for _, m := range ms {
+		if m.msgType == "stream" && m.content["name"] == "stdout" {
+			s += m.content["text"].(string)
+		}
+	}
+	return s
+}
+
+func find(ms []*testMessage, msgtype string) *testMessage {
+	for _, m := range ms {
+		if m.msgType == msgtype {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestKernelInfo(t *testing.T) {
+	k := newTestKernel(t)
+	id := k.request(k.shell, "kernel_info_request", map[string]any{})
+	rep := k.reply(k.shell, id, "kernel_info_reply")
+	if rep["implementation"] != "whitenote" || rep["protocol_version"] != protocolVer {
+		t.Fatalf("kernel_info_reply: %v", rep)
+	}
+	k.published(id)
+}
+
+func TestExecute(t *testing.T) {
+	k := newTestKernel(t)
+	for i := 1; i <= 2; i++ {
+		// push 1; push 2; add; outnum; end
+		id := k.request(k.shell, "execute_request", map[string]any{
+			"code": "   \t\n   \t \n\t   \t\n \t\n\n\n",
+		})
+		rep := k.reply(k.shell, id, "execute_reply")
+		if rep["status"] != "ok" || rep["execution_count"] != float64(i) {
+			t.Fatalf("execute_reply: %v", rep)
+		}
+		ms := k.published(id)
+		if m := find(ms, "execute_input"); m == nil || m.content["execution_count"] != float64(i) {
+			t.Fatalf("execute_input: %v", m)
+		}
+		if out := stdout(ms); out != "3" {
+			t.Fatalf("stdout: %q", out)
+		}
+	}
+}
+
+func TestExecuteInput(t *testing.T) {
+	k := newTestKernel(t)
+	// push 0; readnum; push 0; retrieve; outnum; end
+	id := k.request(k.shell, "execute_request", map[string]any{
+		"code":        "   \n\t\n\t\t   \n\t\t\t\t\n \t\n\n\n",
+		"allow_stdin": true,
+	})
+	req := k.recv(k.stdin)
+	if req.msgType != "input_request" || req.parent != id {
+		t.Fatalf("input_request: %v (parent=%v)", req.msgType, req.parent)
+	}
+	k.request(k.stdin, "input_reply", map[string]any{"value": "42"})
+
+	if rep := k.reply(k.shell, id, "execute_reply"); rep["status"] != "ok" {
+		t.Fatalf("execute_reply: %v", rep)
+	}
+	if out := stdout(k.published(id)); out != "42" {
+		t.Fatalf("stdout: %q", out)
+	}
+}
+
+func TestExecuteInputEOF(t *testing.T) {
+	k := newTestKernel(t)
+	// push 0; readchar; push 0; retrieve; outnum; end
+	code := "   \n\t\n\t    \n\t\t\t\t\n \t\n\n\n"
+
+	// reading without stdin is an error regardless of the EOF policy.
+	for _, eof := range []wspace.EOFPolicy{wspace.EOFError, wspace.EOFMinusOne} {
+		k.vm.EOF = eof
+		id := k.request(k.shell, "execute_request", map[string]any{"code": code, "allow_stdin": false})
+		rep := k.reply(k.shell, id, "execute_reply")
+		if rep["status"] != "error" || rep["evalue"] != "heap[0]: stdin is not allowed" {
+			t.Fatalf("execute_reply (EOF policy %v): %v", eof, rep)
+		}
+		k.published(id)
+	}
+}
+
+func TestExecuteSilent(t *testing.T) {
+	k := newTestKernel(t)
+	// %stack; push 1; push 2; add; outnum; end
+	id := k.request(k.shell, "execute_request", map[string]any{
+		"code":   "%stack\n   \t\n   \t \n\t   \t\n \t\n\n\n",
+		"silent": true,
+	})
+	if rep := k.reply(k.shell, id, "execute_reply"); rep["status"] != "ok" || rep["execution_count"] != float64(0) {
+		t.Fatalf("execute_reply: %v", rep)
+	}
+	for _, m := range k.published(id) {
+		if m.msgType != "status" {
+			t.Fatalf("published on silent: %v %v", m.msgType, m.content)
+		}
+	}
+
+	id = k.request(k.shell, "execute_request", map[string]any{"code": "\t\n\n", "silent": true})
+	if rep := k.reply(k.shell, id, "execute_reply"); rep["status"] != "error" {
+		t.Fatalf("execute_reply: %v", rep)
+	}
+	if m := find(k.published(id), "error"); m != nil {
+		t.Fatalf("error on silent: %v", m.content)
+	}
+}
+
+func TestExecuteError(t *testing.T) {
+	k := newTestKernel(t)
+
+	id := k.request(k.shell, "execute_request", map[string]any{"code": "\t\n\n"})
+	rep := k.reply(k.shell, id, "execute_reply")
+	if rep["status"] != "error" || rep["ename"] != "LoadingError" {
+		t.Fatalf("execute_reply: %v", rep)
+	}
+	if m := find(k.published(id), "error"); m == nil || m.content["ename"] != "LoadingError" {
+		t.Fatalf("error: %v", m)
+	}
+
+	// push 0; readnum; discard on the empty stack.
+	// the request queued while waiting for the input is aborted.
+	id1 := k.request(k.shell, "execute_request", map[string]any{"code": "   \n\t\n\t\t \n\n\n\n\n"})
+	k.recv(k.stdin)
+	id2 := k.request(k.shell, "execute_request", map[string]any{"code": "\n\n\n"})
+	k.request(k.stdin, "input_reply", map[string]any{"value": "1"})
+	rep = k.reply(k.shell, id1, "execute_reply")
+	if rep["status"] != "error" || rep["ename"] != "RuntimeError" {
+		t.Fatalf("execute_reply: %v", rep)
+	}
+	if rep := k.reply(k.shell, id2, "execute_reply"); rep["status"] != "aborted" {
+		t.Fatalf("execute_reply of the queued request: %v", rep)
+	}
+
+	// the next request runs.
+	id = k.request(k.shell, "execute_request", map[string]any{"code": "\n\n\n"})
+	if rep := k.reply(k.shell, id, "execute_reply"); rep["status"] != "ok" {
+		t.Fatalf("execute_reply after abort: %v", rep)
+	}
+}
+
+func TestIsComplete(t *testing.T) {
+	k := newTestKernel(t)
+	tests := map[string]struct {
+		code   string
+		status string
+	}{
+		"empty":              {"", "complete"},
+		"complete":           {"   \t\n\t\n \t", "complete"},
+		"comment":            {"push 1:   \t\n", "complete"},
+		"incomplete":         {"   \t", "incomplete"},
+		"incomplete command": {"   \t\n\t\n", "incomplete"},
+		"invalid":            {"\t\n\n", "invalid"},
+		"magic":              {"%stack\n", "complete"},
+		"magic complete":     {"%stack\n%heap\n   \t\n", "complete"},
+		"magic incomplete":   {"%stack\n   \t", "incomplete"},
+		"magic invalid":      {"%stack\n\t\n\n", "invalid"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			id := k.request(k.shell, "is_complete_request", map[string]any{"code": test.code})
+			rep := k.reply(k.shell, id, "is_complete_reply")
+			if rep["status"] != test.status {
+				t.Fatalf("is_complete_reply: %v, wants %v", rep, test.status)
+			}
+			if _, ok := rep["indent"]; ok != (test.status == "incomplete") {
+				t.Fatalf("indent: %v", rep)
+			}
+		})
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	k := newTestKernel(t)
+
+	// push 1; outnum; L: jump L
+	id := k.request(k.shell, "execute_request", map[string]any{"code": "   \t\n\t\n \t\n  \t\n\n \n\t\n"})
+	// the cell is running when its output is published.
+	for {
+		m := k.recv(k.iopub)
+		if m.parent == id && m.msgType == "stream" {
+			break
+		}
+	}
+	iid := k.request(k.control, "interrupt_request", map[string]any{})
+	if rep := k.reply(k.control, iid, "interrupt_reply"); rep["status"] != "ok" {
+		t.Fatalf("interrupt_reply: %v", rep)
+	}
+	rep := k.reply(k.shell, id, "execute_reply")
+	if rep["status"] != "error" || rep["ename"] != "KeyboardInterrupt" {
+		t.Fatalf("execute_reply: %v", rep)
+	}
+	if m := find(k.published(id), "error"); m == nil || m.content["ename"] != "KeyboardInterrupt" {
+		t.Fatalf("error: %v", m)
+	}
+
+	// the next request runs.
+	id = k.request(k.shell, "execute_request", map[string]any{"code": "   \t \n\t\n \t\n\n\n"})
+	if rep := k.reply(k.shell, id, "execute_reply"); rep["status"] != "ok" {
+		t.Fatalf("execute_reply after interrupt: %v", rep)
+	}
+	if out := stdout(k.published(id)); out != "2" {
+		t.Fatalf("stdout after interrupt: %q", out)
+	}
+}
+
+func TestInvalidSignature(t *testing.T) {
+	k := newTestKernel(t)
+	hdr := newHeader("kernel_info_request")
+	k.shell.send([]byte(delimiter), []byte("bad"), hdr, []byte("{}"), metadata, []byte("{}"))
+	if mb, ok := k.shell.recv(100 * time.Millisecond); ok {
+		t.Fatalf("replied to the invalid signature: %q", mb)
+	}
+
+	id := k.request(k.shell, "kernel_info_request", map[string]any{})
+	k.reply(k.shell, id, "kernel_info_reply")
+}
+
+func TestHeartbeat(t *testing.T) {
+	k := newTestKernel(t)
+	k.hb.send([]byte("ping"))
+	mb, ok := k.hb.recv(testTimeout)
+	if !ok || len(mb) != 1 || string(mb[0]) != "ping" {
+		t.Fatalf("heartbeat: %q", mb)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	k := newTestKernel(t)
+	id := k.request(k.control, "shutdown_request", map[string]any{"restart": false})
+	if rep := k.reply(k.control, id, "shutdown_reply"); rep["status"] != "ok" || rep["restart"] != false {
+		t.Fatalf("shutdown_reply: %v", rep)
+	}
+	select {
+	case <-k.shutdown:
+	case <-time.After(testTimeout):
+		t.Fatalf("not shut down")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	k := newTestKernel(t)
+	// push 2
+	id := k.request(k.shell, "execute_request", map[string]any{"code": "   \t \n", "store_history": true})
+	if rep := k.reply(k.shell, id, "execute_reply"); rep["execution_count"] != float64(1) {
+		t.Fatalf("execute_reply: %v", rep)
+	}
+	id = k.request(k.shell, "comm_open", map[string]any{"comm_id": "a", "target_name": vmCommTarget, "data": map[string]any{}})
+	k.published(id)
+	id, _ = k.debug(1, "initialize", map[string]any{"clientID": "test", "adapterID": "whitenote"})
+	k.event(id, "initialized")
+	k.debug(2, "attach", map[string]any{})
+
+	id = k.request(k.control, "shutdown_request", map[string]any{"restart": true})
+	if rep := k.reply(k.control, id, "shutdown_reply"); rep["status"] != "ok" || rep["restart"] != true {
+		t.Fatalf("shutdown_reply: %v", rep)
+	}
+	select {
+	case <-k.shutdown:
+		t.Fatalf("shut down on restart")
+	default:
+	}
+
+	// the stack is empty and the execution count starts from 1 again.
+	// outnum
+	id = k.request(k.shell, "execute_request", map[string]any{"code": "\t\n \t", "store_history": true})
+	if rep := k.reply(k.shell, id, "execute_reply"); rep["status"] != "error" || rep["execution_count"] != float64(1) {
+		t.Fatalf("execute_reply after restart: %v", rep)
+	}
+
+	// the cells after the restart are in the new session.
+	id = k.request(k.shell, "history_request", map[string]any{"hist_access_type": "range"})
+	hist, _ := k.reply(k.shell, id, "history_reply")["history"].([]any)
+	if len(hist) != 1 || hist[0].([]any)[2] != "\t\n \t" {
+		t.Fatalf("history of the current session: %v", hist)
+	}
+	id = k.request(k.shell, "history_request", map[string]any{"hist_access_type": "range", "session": -1})
+	hist, _ = k.reply(k.shell, id, "history_reply")["history"].([]any)
+	if len(hist) != 1 || hist[0].([]any)[2] != "   \t \n" {
+		t.Fatalf("history of the previous session: %v", hist)
+	}
+
+	// the comms and the debugger are reset.
+	id = k.request(k.shell, "comm_info_request", map[string]any{})
+	if comms, _ := k.reply(k.shell, id, "comm_info_reply")["comms"].(map[string]any); len(comms) != 0 {
+		t.Fatalf("comms after restart: %v", comms)
+	}
+	if k.socks.debugger.active() {
+		t.Fatalf("debugger is active after restart")
+	}
+}
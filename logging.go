@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// levelTrace is the level of the wire trace, which records every multipart message.
+const levelTrace = slog.LevelDebug - 4
+
+// redacted replaces the signature in the wire trace.
+const redacted = "<redacted>"
+
+// parseLevel parses the log level: trace, debug, info, warn or error.
+func parseLevel(s string) (slog.Level, error) {
+	if strings.EqualFold(s, "trace") {
+		return levelTrace, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: trace, debug, info, warn or error is expected", s)
+	}
+	return l, nil
+}
+
+// newLogger returns the logger writing to w.
+// The wire trace is enabled by the level of trace.
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && a.Value.Any() == levelTrace {
+				a.Value = slog.StringValue("TRACE")
+			}
+			return a
+		},
+	}))
+}
+
+// setupLogger sets the default logger by the level and the file.
+// The returned function closes the log file.
+func setupLogger(level, file string) (func(), error) {
+	l, err := parseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		slog.SetDefault(newLogger(os.Stderr, l))
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(newLogger(f, l))
+	return func() { f.Close() }, nil
+}
+
+// getenv returns the environment variable or the default value.
+func getenv(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// tracingSocket records the messages sent and received by the socket.
+type tracingSocket struct {
+	socket
+	name string
+}
+
+// traceSocket wraps the socket to record the wire trace if it is enabled.
+func traceSocket(sock socket, name string) socket {
+	if !slog.Default().Enabled(context.Background(), levelTrace) {
+		return sock
+	}
+	return &tracingSocket{socket: sock, name: name}
+}
+
+func (s *tracingSocket) Recv() ([][]byte, error) {
+	parts, err := s.socket.Recv()
+	if err == nil {
+		s.trace("recv", parts)
+	}
+	return parts, err
+}
+
+func (s *tracingSocket) Send(parts ...[]byte) error {
+	s.trace("send", parts)
+	return s.socket.Send(parts...)
+}
+
+func (s *tracingSocket) trace(dir string, parts [][]byte) {
+	slog.Log(context.Background(), levelTrace, dir, "socket", s.name, "parts", redact(parts))
+}
+
+// redact returns the parts as strings with the signature following the delimiter redacted.
+func redact(parts [][]byte) []string {
+	ss := make([]string, len(parts))
+	sig := -1
+	for i, p := range parts {
+		if i == sig && len(p) > 0 {
+			ss[i] = redacted
+			continue
+		}
+		if bytes.Equal(p, []byte(delimiter)) && sig < 0 {
+			sig = i + 1
+		}
+		ss[i] = string(p)
+	}
+	return ss
+}
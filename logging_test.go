@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"trace": levelTrace,
+		"TRACE": levelTrace,
+		"debug": slog.LevelDebug,
+		"info":  slog.LevelInfo,
+		"WARN":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for s, want := range tests {
+		l, err := parseLevel(s)
+		if err != nil || l != want {
+			t.Errorf("%s: %v %v, wants %v", s, l, err, want)
+		}
+	}
+	if _, err := parseLevel("verbose"); err == nil {
+		t.Errorf("no error for the invalid level")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	parts := msg("id", delimiter, "0123abcd", "{}", "{}", "{}", "{}", delimiter)
+	want := []string{"id", delimiter, redacted, "{}", "{}", "{}", "{}", delimiter}
+	got := redact(parts)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("redact: %q, wants %q", got, want)
+	}
+	// the empty signature of the unsigned message is kept.
+	if got := redact(msg(delimiter, "", "{}")); got[1] != "" {
+		t.Fatalf("redact unsigned: %q", got)
+	}
+}
+
+func TestWireTrace(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(&buf, levelTrace))
+
+	k := newTestKernel(t)
+	id := k.request(k.shell, "kernel_info_request", map[string]any{})
+	k.reply(k.shell, id, "kernel_info_reply")
+	k.published(id)
+	k.close()
+
+	log := buf.String()
+	for _, s := range []string{"level=TRACE msg=recv socket=shell", "level=TRACE msg=send socket=shell", "level=TRACE msg=send socket=iopub", redacted, "kernel_info_reply"} {
+		if !strings.Contains(log, s) {
+			t.Errorf("%q is not logged", s)
+		}
+	}
+	if sig := regexp.MustCompile(`[0-9a-f]{64}`).FindString(log); sig != "" {
+		t.Errorf("signature is logged: %v", sig)
+	}
+}
+
+func TestInvalidSignatureLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(&buf, slog.LevelDebug))
+
+	k := newTestKernel(t)
+	hdr := newHeader("kernel_info_request")
+	k.shell.send([]byte(delimiter), []byte("0123456789abcdef"), hdr, []byte("{}"), metadata, []byte(`{"code":"secret"}`))
+	id := k.request(k.shell, "kernel_info_request", map[string]any{})
+	k.reply(k.shell, id, "kernel_info_reply")
+	k.close()
+
+	log := buf.String()
+	if !strings.Contains(log, `invalid hmac: msg_type=\"kernel_info_request\"`) {
+		t.Errorf("invalid hmac is not logged: %s", log)
+	}
+	for _, s := range []string{"0123456789abcdef", "secret", "username"} {
+		if strings.Contains(log, s) {
+			t.Errorf("%q is logged: %s", s, log)
+		}
+	}
+}
+
+func msg(ss ...string) [][]byte {
+	parts := make([][]byte, len(ss))
+	for i, s := range ss {
+		parts[i] = []byte(s)
+	}
+	return parts
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+// line magics
+//
+//	%reset   reset the VM
+//	%stack   show the stack
+//	%heap    show the heap
+//	%labels  show the labels
+//	%disasm  disassemble the loaded program
+//	%trace   trace the execution of the cell
+var magicNames = map[string]bool{
+	"%reset":  true,
+	"%stack":  true,
+	"%heap":   true,
+	"%labels": true,
+	"%disasm": true,
+	"%trace":  true,
+}
+
+// parseMagics splits the magic lines at the beginning of the code from the rest.
+// Only the known magics are recognized so that the comments in the code are kept.
+func parseMagics(code []byte) ([]string, []byte) {
+	var magics []string
+	for bytes.HasPrefix(code, []byte{'%'}) {
+		line, rest := code, []byte(nil)
+		if i := bytes.IndexByte(code, '\n'); i >= 0 {
+			line, rest = code[:i], code[i+1:]
+		}
+		name := strings.TrimRight(string(line), " \t\r")
+		if !magicNames[name] {
+			break
+		}
+		magics = append(magics, name)
+		code = rest
+	}
+	return magics, code
+}
+
+// runMagics runs the magics and reports whether the cell is traced.
+// The displays are not sent when silent.
+// vmMu must be locked.
+func (s *Sockets) runMagics(vm *wspace.VM, msg *Message, magics []string, silent bool) bool {
+	trace := false
+	for _, m := range magics {
+		var data map[string]any
+		switch m {
+		case "%reset":
+			*vm = *newVM()
+			s.sources = make(map[int]cellSource)
+		case "%stack":
+			data = stackData(vm)
+		case "%heap":
+			data = heapData(vm)
+		case "%labels":
+			data = labelsData(vm)
+		case "%disasm":
+			data = disasmData(vm)
+		case "%trace":
+			trace = !trace
+		}
+		if data != nil && !silent {
+			s.sendDisplayData(msg, data)
+		}
+	}
+	return trace
+}
+
+func tableData(caption string, head []string, rows [][]string) map[string]any {
+	var t strings.Builder
+	fmt.Fprintln(&t, strings.Join(head, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(&t, strings.Join(r, "\t"))
+	}
+	var h strings.Builder
+	writeTable(&h, caption, head, rows)
+	return map[string]any{
+		"text/plain": t.String(),
+		"text/html":  h.String(),
+	}
+}
+
+func stackData(vm *wspace.VM) map[string]any {
+	rows := make([][]string, 0, len(vm.Stack))
+	for i := len(vm.Stack) - 1; i >= 0; i-- {
+		rows = append(rows, []string{strconv.Itoa(len(vm.Stack) - 1 - i), strconv.Itoa(vm.Stack[i])})
+	}
+	return tableData("stack", []string{"#", "value"}, rows)
+}
+
+func heapData(vm *wspace.VM) map[string]any {
+	addrs := make([]int, 0, len(vm.Heap))
+	for a := range vm.Heap {
+		addrs = append(addrs, a)
+	}
+	sort.Ints(addrs)
+	rows := make([][]string, 0, len(addrs))
+	for _, a := range addrs {
+		rows = append(rows, []string{strconv.Itoa(a), strconv.Itoa(vm.Heap[a])})
+	}
+	return tableData("heap", []string{"address", "value"}, rows)
+}
+
+func labelsData(vm *wspace.VM) map[string]any {
+	names := make([]string, 0, len(vm.Labels))
+	for l := range vm.Labels {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+	rows := make([][]string, 0, len(names))
+	for _, l := range names {
+		rows = append(rows, []string{wspace.LabelName(l), programPos(vm, vm.Labels[l])})
+	}
+	return tableData("labels", []string{"label", "defined at"}, rows)
+}
+
+func disasmData(vm *wspace.VM) map[string]any {
+	rows := make([][]string, 0, len(vm.Program))
+	for i := range vm.Program {
+		op := &vm.Program[i]
+		rows = append(rows, []string{strconv.Itoa(i), programPos(vm, i), op.Cmd.String(), opParam(op)})
+	}
+	return tableData("program", []string{"#", "position", "command", "parameter"}, rows)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMagics(t *testing.T) {
+	tests := map[string]struct {
+		code   string
+		magics []string
+		rest   string
+	}{
+		"none":      {"   \t\n", nil, "   \t\n"},
+		"magic":     {"%stack\n   \t\n", []string{"%stack"}, "   \t\n"},
+		"magics":    {"%reset\n%trace \t\r\n%heap\n\n\n\n", []string{"%reset", "%trace", "%heap"}, "\n\n\n"},
+		"only":      {"%disasm", []string{"%disasm"}, ""},
+		"comment":   {"% push 1\n   \t\n", nil, "% push 1\n   \t\n"},
+		"unknown":   {"%stack\n%unknown\n%heap\n", []string{"%stack"}, "%unknown\n%heap\n"},
+		"after":     {"   \t\n%stack\n", nil, "   \t\n%stack\n"},
+		"indented":  {" %stack\n", nil, " %stack\n"},
+		"code line": {"%labels\npush 1:   \t\n", []string{"%labels"}, "push 1:   \t\n"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			magics, rest := parseMagics([]byte(test.code))
+			if !reflect.DeepEqual(magics, test.magics) || string(rest) != test.rest {
+				t.Fatalf("%q, %q, wants %q, %q", magics, rest, test.magics, test.rest)
+			}
+		})
+	}
+}
+
+func TestMagicCursor(t *testing.T) {
+	k := newTestKernel(t)
+
+	// the cursor is at the Push after the magic line.
+	id := k.request(k.shell, "inspect_request", map[string]any{"code": "%stack\n   \t\n", "cursor_pos": 8})
+	if rep := k.reply(k.shell, id, "inspect_reply"); rep["found"] != true {
+		t.Fatalf("inspect_reply: %v", rep)
+	}
+	id = k.request(k.shell, "inspect_request", map[string]any{"code": "%stack\n   \t\n", "cursor_pos": 3})
+	if rep := k.reply(k.shell, id, "inspect_reply"); rep["found"] != false {
+		t.Fatalf("inspect_reply in the magic: %v", rep)
+	}
+
+	id = k.request(k.shell, "complete_request", map[string]any{"code": "%stack\n ", "cursor_pos": 8})
+	rep := k.reply(k.shell, id, "complete_reply")
+	items, _ := rep["metadata"].(map[string]any)["_jupyter_types_experimental"].([]any)
+	if len(items) == 0 || items[0].(map[string]any)["type"] != "Push" || rep["cursor_start"] != float64(8) {
+		t.Fatalf("complete_reply: %v", rep)
+	}
+}
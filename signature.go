@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// signatureSchemes are the supported signature schemes of the connection file.
+var signatureSchemes = map[string]func() hash.Hash{
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+	"hmac-md5":    md5.New,
+}
+
+// signer signs and verifies the messages.
+// The messages are not signed if the key is empty.
+type signer struct {
+	hash func() hash.Hash
+	key  []byte
+}
+
+// newSigner returns the signer of the scheme.
+// The scheme defaults to hmac-sha256 when it is empty.
+func newSigner(scheme, key string) (*signer, error) {
+	if scheme == "" {
+		scheme = "hmac-sha256"
+	}
+	h, ok := signatureSchemes[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature scheme %q: hmac-sha256, hmac-sha512 or hmac-md5 is expected", scheme)
+	}
+	return &signer{hash: h, key: []byte(key)}, nil
+}
+
+func (s *signer) mac(header, parent, metadata, content []byte) []byte {
+	h := hmac.New(s.hash, s.key)
+	h.Write(header)
+	h.Write(parent)
+	h.Write(metadata)
+	h.Write(content)
+	return h.Sum(nil)
+}
+
+// sign returns the hex-encoded signature, or an empty string for the unsigned messages.
+func (s *signer) sign(header, parent, metadata, content []byte) string {
+	if len(s.key) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(s.mac(header, parent, metadata, content))
+}
+
+// verify reports whether the signature is valid, comparing it in constant time.
+// Any signature is accepted for the unsigned messages.
+func (s *signer) verify(sig string, header, parent, metadata, content []byte) bool {
+	if len(s.key) == 0 {
+		return true
+	}
+	b, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(b, s.mac(header, parent, metadata, content))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+func TestNewSigner(t *testing.T) {
+	if _, err := newSigner("hmac-sha1", "key"); err == nil {
+		t.Fatalf("no error for the unsupported scheme")
+	}
+	s, err := newSigner("", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, content := []byte(`{"msg_id":"a"}`), []byte(`{}`)
+	sig := s.sign(hdr, nil, nil, content)
+	if len(sig) != 64 {
+		t.Fatalf("signature of hmac-sha256: %q", sig)
+	}
+	if !s.verify(sig, hdr, nil, nil, content) {
+		t.Fatalf("valid signature is rejected")
+	}
+	for _, bad := range []string{"", "xyz", sig[:62], sig[:63] + "0"} {
+		if s.verify(bad, hdr, nil, nil, content) {
+			t.Fatalf("invalid signature is accepted: %q", bad)
+		}
+	}
+}
+
+func TestSignatureScheme(t *testing.T) {
+	tests := map[string]func() hash.Hash{
+		"hmac-sha512": sha512.New,
+		"hmac-md5":    md5.New,
+	}
+	for scheme, h := range tests {
+		t.Run(scheme, func(t *testing.T) {
+			k := newSignedKernel(t, scheme, "secret")
+			k.request(k.shell, "kernel_info_request", map[string]any{})
+			mb, ok := k.shell.recv(testTimeout)
+			if !ok {
+				t.Fatalf("no reply")
+			}
+			mac := hmac.New(h, []byte("secret"))
+			for _, p := range mb[2:6] {
+				mac.Write(p)
+			}
+			if sig := hex.EncodeToString(mac.Sum(nil)); string(mb[1]) != sig {
+				t.Fatalf("signature: %q, wants %q", mb[1], sig)
+			}
+		})
+	}
+}
+
+func TestUnsigned(t *testing.T) {
+	k := newSignedKernel(t, "hmac-sha256", "")
+	hdr := newHeader("kernel_info_request")
+	k.shell.send([]byte(delimiter), []byte(""), hdr, []byte("{}"), metadata, []byte("{}"))
+	mb, ok := k.shell.recv(testTimeout)
+	if !ok {
+		t.Fatalf("no reply")
+	}
+	if len(mb[1]) != 0 {
+		t.Fatalf("reply is signed: %q", mb[1])
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	streamFlushSize     = 4096                   // flush when the buffer exceeds this size
+	streamFlushInterval = 100 * time.Millisecond // flush the buffered output after this interval
+)
+
+// streamWriter sends the output to iopub as the stream messages while the execution.
+// The small writes are coalesced and flushed on a newline, when the buffer exceeds
+// streamFlushSize, or after streamFlushInterval.
+type streamWriter struct {
+	socks  *Sockets
+	parent *Message
+	name   string // "stdout" or "stderr"
+	silent bool   // discard the output
+
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	last  time.Time // time of the last flush
+	timer *time.Timer
+}
+
+func newStreamWriter(socks *Sockets, parent *Message, name string, silent bool) *streamWriter {
+	return &streamWriter{
+		socks:  socks,
+		parent: parent,
+		name:   name,
+		silent: silent,
+		last:   time.Now(),
+	}
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.silent {
+		return len(p), nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	switch {
+	case w.buf.Len() >= streamFlushSize:
+		w.flush()
+	case bytes.IndexByte(p, '\n') >= 0 && time.Since(w.last) >= streamFlushInterval:
+		w.flush()
+	case w.timer == nil:
+		w.timer = time.AfterFunc(streamFlushInterval, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.timer = nil
+			w.flush()
+		})
+	}
+	return len(p), nil
+}
+
+// Flush sends the buffered output.
+func (w *streamWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+}
+
+// Close sends the rest of the output including an incomplete character.
+func (w *streamWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+	if w.buf.Len() > 0 {
+		w.socks.sendStream(w.parent, w.name, w.buf.String())
+		w.buf.Reset()
+	}
+	return nil
+}
+
+func (w *streamWriter) flush() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.last = time.Now()
+
+	// an incomplete UTF-8 sequence at the end is left for the next flush.
+	b := w.buf.Bytes()
+	n := len(b) - incompleteRune(b)
+	if n == 0 {
+		return
+	}
+	w.socks.sendStream(w.parent, w.name, string(b[:n]))
+	w.buf.Next(n)
+}
+
+// incompleteRune returns the length of the incomplete UTF-8 sequence at the end of b.
+func incompleteRune(b []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIncompleteRune(t *testing.T) {
+	tests := map[string]struct {
+		b      string
+		expect int
+	}{
+		"empty":        {"", 0},
+		"ascii":        {"abc", 0},
+		"complete":     {"aあ", 0},
+		"first byte":   {"a\xe3", 1},
+		"second byte":  {"a\xe3\x81", 2},
+		"four bytes":   {"\xf0\x9f\x98", 3},
+		"emoji":        {"\xf0\x9f\x98\x80", 0},
+		"continuation": {"\x81", 0},
+		"invalid":      {"a\xff", 0},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if n := incompleteRune([]byte(test.b)); n != test.expect {
+				t.Fatalf("incompleteRune: %v, wants %v", n, test.expect)
+			}
+		})
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	tests := map[string]struct {
+		silent bool
+		writes []string // flushed after each write
+		expect []string // the streams sent by the writes and Close
+	}{
+		"lines":       {false, []string{"a\n", "b", "c\n"}, []string{"a\n", "b", "c\n"}},
+		"split rune":  {false, []string{"a\xe3", "\x81", "\x82b"}, []string{"a", "あb"}},
+		"split emoji": {false, []string{"\xf0", "\x9f\x98", "\x80"}, []string{"😀"}},
+		"close":       {false, []string{"x\xe3\x81"}, []string{"x", "\ufffd\ufffd"}},
+		"large":       {false, []string{strings.Repeat("a", streamFlushSize)}, []string{strings.Repeat("a", streamFlushSize)}},
+		"silent":      {true, []string{"a\n", "b"}, nil},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			k := newTestKernel(t)
+			parent := &Message{Header: []byte(`{"msg_id":"parent"}`)}
+			w := newStreamWriter(k.socks, parent, "stdout", test.silent)
+			for _, s := range test.writes {
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write: %v, %v", n, err)
+				}
+				w.Flush()
+			}
+			w.Close()
+			k.socks.sendStream(parent, "stderr", "end")
+
+			var ss []string
+			for {
+				m := k.recv(k.iopub)
+				if m.parent != "parent" || m.msgType != "stream" {
+					continue
+				}
+				if m.content["name"] == "stderr" {
+					break
+				}
+				ss = append(ss, m.content["text"].(string))
+			}
+			if !reflect.DeepEqual(ss, test.expect) {
+				t.Fatalf("streams: %q, wants %q", ss, test.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiRed     = "\x1b[0;31m"
+	ansiGreen   = "\x1b[0;32m"
+	ansiCyan    = "\x1b[0;36m"
+	ansiBoldRed = "\x1b[1;31m"
+)
+
+// cellSource is the source code of a loaded segment.
+type cellSource struct {
+	src  []byte // whole code of the cell
+	off  int    // offset of the loaded code in src (after the magics)
+	path string // source path for the debugger
+}
+
+// traceback returns the traceback lines of the error at the position of the segment.
+// vmMu must be locked.
+func (s *Sockets) traceback(vm *wspace.VM, ename, evalue string, seg, pos int, cmd wspace.Command) []string {
+	tb := []string{fmt.Sprintf("%s%s%s: %s", ansiRed, ename, ansiReset, evalue)}
+
+	if c, ok := s.sources[seg]; ok && c.off+pos <= len(c.src) {
+		p := c.off + pos
+		loc := fmt.Sprintf("segment %v, line %v", seg, lineNum(c.src, p))
+		if cmd != 0 {
+			loc += fmt.Sprint(": ", cmd)
+		}
+		line, col := sourceLine(c.src, p)
+		tb = append(tb,
+			fmt.Sprintf("%s%s%s", ansiCyan, loc, ansiReset),
+			fmt.Sprintf("    %s%s%s", ansiGreen, line, ansiReset),
+			fmt.Sprintf("    %s%s^%s", strings.Repeat(" ", col), ansiBoldRed, ansiReset))
+	}
+
+	if len(vm.CallStack) > 0 {
+		chain := []string{"(main)"}
+		for _, ret := range vm.CallStack {
+			if ret > 0 && ret <= len(vm.Program) {
+				if l, ok := vm.Program[ret-1].Param.(string); ok {
+					chain = append(chain, wspace.LabelName(l))
+					continue
+				}
+			}
+			chain = append(chain, "?")
+		}
+		tb = append(tb, "callstack: "+strings.Join(chain, " -> "))
+	}
+
+	if cmd != 0 {
+		const n = 8
+		st := vm.Stack
+		top := fmt.Sprint(st)
+		if len(st) > n {
+			top = fmt.Sprint("[... ", strings.Trim(fmt.Sprint(st[len(st)-n:]), "[]"), "]")
+		}
+		tb = append(tb, "stack: "+top+" (top is right)")
+	}
+	return tb
+}
+
+// sourceLine returns the line containing the position in the visible notation
+// and the column of the position.
+func sourceLine(src []byte, pos int) (string, int) {
+	start := bytes.LastIndexByte(src[:pos], '\n') + 1
+	end := len(src)
+	if i := bytes.IndexByte(src[pos:], '\n'); i >= 0 {
+		end = pos + i + 1
+	}
+	return wspace.Visualize(string(src[start:end])), utf8.RuneCount(src[start:pos])
+}
+
+func (s *Sockets) sendError(parent *Message, ename, evalue string, traceback []string) {
+	content, _ := json.Marshal(map[string]any{
+		"ename":     ename,
+		"evalue":    evalue,
+		"traceback": traceback,
+	})
+	s.send(s.iopub, parent, "error", content)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// socketType is the type of the kernel socket.
+type socketType int
+
+const (
+	routerSocket socketType = iota // shell, control and stdin
+	pubSocket                      // iopub
+	repSocket                      // heartbeat
+)
+
+// errTerminated is returned by the sockets after the transport is terminated.
+var errTerminated = errors.New("transport terminated")
+
+// socket sends and receives the multipart messages.
+type socket interface {
+	// Recv receives a multipart message. The ROUTER socket prepends the identity of the peer.
+	Recv() ([][]byte, error)
+	// Send sends a multipart message. The ROUTER socket routes it by the first part.
+	Send(parts ...[]byte) error
+	// Poll waits for a message until the timeout, and reports whether it is ready to be received.
+	Poll(timeout time.Duration) (bool, error)
+	Close() error
+}
+
+// transport creates the sockets and terminates them at once.
+type transport interface {
+	// bind returns the socket bound to the endpoint, and the endpoint actually bound,
+	// which contains the port chosen by the system for "tcp://<ip>:*".
+	bind(typ socketType, endpoint string) (socket, string, error)
+	// term terminates the transport. The blocking Recv of the sockets returns errTerminated.
+	term() error
+}
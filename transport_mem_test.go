@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memTransport is the in-memory transport for the tests.
+// The ports chosen by the transport start from 50001.
+// The clients are connected to the sockets by the endpoints.
+type memTransport struct {
+	mu    sync.Mutex
+	socks map[string]*memSocket
+	done  chan struct{}
+	port  int // the last port chosen for "tcp://<ip>:*"
+}
+
+func newMemTransport() *memTransport {
+	return &memTransport{
+		socks: make(map[string]*memSocket),
+		done:  make(chan struct{}),
+		port:  50000,
+	}
+}
+
+func (t *memTransport) bind(typ socketType, endpoint string) (socket, string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.socks[endpoint] != nil {
+		return nil, "", errors.New("address already in use")
+	}
+	if strings.HasSuffix(endpoint, ":*") {
+		t.port++
+		endpoint = fmt.Sprintf("%s%d", endpoint[:len(endpoint)-1], t.port)
+	}
+	s := &memSocket{
+		typ:     typ,
+		done:    t.done,
+		inbox:   make(chan [][]byte, 256),
+		clients: make(map[string]*memClient),
+	}
+	t.socks[endpoint] = s
+	return s, endpoint, nil
+}
+
+func (t *memTransport) term() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	return nil
+}
+
+// connect connects a client with the identity to the socket bound to the endpoint.
+func (t *memTransport) connect(endpoint, identity string) *memClient {
+	t.mu.Lock()
+	s := t.socks[endpoint]
+	t.mu.Unlock()
+	c := &memClient{
+		id:    identity,
+		sock:  s,
+		inbox: make(chan [][]byte, 256),
+	}
+	s.mu.Lock()
+	s.clients[identity] = c
+	s.mu.Unlock()
+	return c
+}
+
+// memSocket is the kernel side socket of memTransport.
+type memSocket struct {
+	typ   socketType
+	done  chan struct{}
+	inbox chan [][]byte
+
+	mu      sync.Mutex
+	clients map[string]*memClient
+	peeked  [][]byte
+	last    *memClient // the client to be replied by REP
+}
+
+func (s *memSocket) Recv() ([][]byte, error) {
+	s.mu.Lock()
+	msg := s.peeked
+	s.peeked = nil
+	s.mu.Unlock()
+	if msg != nil {
+		return msg, nil
+	}
+	select {
+	case msg := <-s.inbox:
+		return msg, nil
+	case <-s.done:
+		return nil, errTerminated
+	}
+}
+
+func (s *memSocket) Send(parts ...[]byte) error {
+	select {
+	case <-s.done:
+		return errTerminated
+	default:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.typ {
+	case routerSocket:
+		// the message to an unknown client is dropped.
+		if c := s.clients[string(parts[0])]; c != nil {
+			c.inbox <- parts[1:]
+		}
+	case pubSocket:
+		for _, c := range s.clients {
+			c.inbox <- parts
+		}
+	case repSocket:
+		if s.last != nil {
+			s.last.inbox <- parts
+			s.last = nil
+		}
+	}
+	return nil
+}
+
+func (s *memSocket) Poll(timeout time.Duration) (bool, error) {
+	s.mu.Lock()
+	ready := s.peeked != nil
+	s.mu.Unlock()
+	if ready {
+		return true, nil
+	}
+	select {
+	case msg := <-s.inbox:
+		s.peek(msg)
+		return true, nil
+	default:
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case msg := <-s.inbox:
+		s.peek(msg)
+		return true, nil
+	case <-t.C:
+		return false, nil
+	case <-s.done:
+		return false, errTerminated
+	}
+}
+
+func (s *memSocket) peek(msg [][]byte) {
+	s.mu.Lock()
+	s.peeked = msg
+	s.mu.Unlock()
+}
+
+func (s *memSocket) Close() error {
+	return nil
+}
+
+// memClient is the frontend side socket connected to memSocket.
+type memClient struct {
+	id    string
+	sock  *memSocket
+	inbox chan [][]byte
+}
+
+func (c *memClient) send(parts ...[]byte) {
+	s := c.sock
+	switch s.typ {
+	case routerSocket:
+		s.inbox <- append([][]byte{[]byte(c.id)}, parts...)
+	case repSocket:
+		s.mu.Lock()
+		s.last = c
+		s.mu.Unlock()
+		s.inbox <- parts
+	}
+}
+
+// recv receives a message until the timeout.
+func (c *memClient) recv(timeout time.Duration) ([][]byte, bool) {
+	select {
+	case msg := <-c.inbox:
+		return msg, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
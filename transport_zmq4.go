@@ -0,0 +1,83 @@
+//go:build cgo && !purego
+
+package main
+
+import (
+	"time"
+
+	"github.com/pebbe/zmq4"
+)
+
+// zmq4Transport is the transport by libzmq.
+type zmq4Transport struct{}
+
+func newTransport() transport {
+	return zmq4Transport{}
+}
+
+func (zmq4Transport) bind(typ socketType, endpoint string) (socket, string, error) {
+	var t zmq4.Type
+	switch typ {
+	case routerSocket:
+		t = zmq4.ROUTER
+	case pubSocket:
+		t = zmq4.PUB
+	case repSocket:
+		t = zmq4.REP
+	}
+	sock, err := zmq4.NewSocket(t)
+	if err != nil {
+		return nil, "", err
+	}
+	sock.SetLinger(time.Second)
+	if err := sock.Bind(endpoint); err != nil {
+		sock.Close()
+		return nil, "", err
+	}
+	bound, err := sock.GetLastEndpoint()
+	if err != nil {
+		sock.Close()
+		return nil, "", err
+	}
+	return &zmq4Socket{sock}, bound, nil
+}
+
+func (zmq4Transport) term() error {
+	return zmq4.Term()
+}
+
+type zmq4Socket struct {
+	sock *zmq4.Socket
+}
+
+func zmq4Error(err error) error {
+	if zmq4.AsErrno(err) == zmq4.ETERM {
+		return errTerminated
+	}
+	return err
+}
+
+func (s *zmq4Socket) Recv() ([][]byte, error) {
+	mb, err := s.sock.RecvMessageBytes(0)
+	return mb, zmq4Error(err)
+}
+
+func (s *zmq4Socket) Send(parts ...[]byte) error {
+	data := make([]any, len(parts))
+	for i, p := range parts {
+		data[i] = p
+	}
+	_, err := s.sock.SendMessage(data...)
+	return zmq4Error(err)
+}
+
+func (s *zmq4Socket) Poll(timeout time.Duration) (bool, error) {
+	poller := zmq4.NewPoller()
+	poller.Add(s.sock, zmq4.POLLIN)
+	polled, err := poller.Poll(timeout)
+	return len(polled) > 0, zmq4Error(err)
+}
+
+func (s *zmq4Socket) Close() error {
+	return s.sock.Close()
+}
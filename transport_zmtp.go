@@ -0,0 +1,82 @@
+//go:build !cgo || purego
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/makiuchi-d/whitenote/zmtp"
+)
+
+// zmtpTransport is the transport by the pure-Go ZMTP implementation.
+type zmtpTransport struct {
+	mu    sync.Mutex
+	socks []*zmtp.Socket
+}
+
+func newTransport() transport {
+	return &zmtpTransport{}
+}
+
+func (t *zmtpTransport) bind(typ socketType, endpoint string) (socket, string, error) {
+	var zt zmtp.Type
+	switch typ {
+	case routerSocket:
+		zt = zmtp.ROUTER
+	case pubSocket:
+		zt = zmtp.PUB
+	case repSocket:
+		zt = zmtp.REP
+	}
+	sock := zmtp.NewSocket(zt)
+	if err := sock.Bind(endpoint); err != nil {
+		sock.Close()
+		return nil, "", err
+	}
+	t.mu.Lock()
+	t.socks = append(t.socks, sock)
+	t.mu.Unlock()
+	return &zmtpSocket{sock}, sock.LastEndpoint(), nil
+}
+
+// term closes all the sockets, which flush the queued messages within the linger period.
+func (t *zmtpTransport) term() error {
+	t.mu.Lock()
+	socks := t.socks
+	t.socks = nil
+	t.mu.Unlock()
+	for _, s := range socks {
+		s.Close()
+	}
+	return nil
+}
+
+type zmtpSocket struct {
+	sock *zmtp.Socket
+}
+
+func zmtpError(err error) error {
+	if err == zmtp.ErrClosed {
+		return errTerminated
+	}
+	return err
+}
+
+func (s *zmtpSocket) Recv() ([][]byte, error) {
+	mb, err := s.sock.Recv()
+	return mb, zmtpError(err)
+}
+
+func (s *zmtpSocket) Send(parts ...[]byte) error {
+	return zmtpError(s.sock.Send(parts...))
+}
+
+func (s *zmtpSocket) Poll(timeout time.Duration) (bool, error) {
+	ok, err := s.sock.Poll(timeout)
+	return ok, zmtpError(err)
+}
+
+func (s *zmtpSocket) Close() error {
+	return s.sock.Close()
+}
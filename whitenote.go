@@ -3,19 +3,19 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/pebbe/zmq4"
 
 	"github.com/makiuchi-d/whitenote/wspace"
 )
@@ -52,29 +52,36 @@ func init() {
 			"codemirror_mode":    "",
 			"nbconvert_exporter": "",
 		},
-		"banner": "",
+		"banner":   "",
+		"debugger": true,
 	})
 }
 
 type Sockets struct {
-	conf    *ConnectionInfo
-	shell   *zmq4.Socket
-	control *zmq4.Socket
-	stdin   *zmq4.Socket
-	iopub   *zmq4.Socket
-	hb      *zmq4.Socket
-}
-
-type ConnectionInfo struct {
-	SignatureScheme string `json:"signature_scheme"`
-	Transport       string `json:"transport"`
-	StdinPort       int    `json:"stdin_port"`
-	ControlPort     int    `json:"control_port"`
-	IOPubPort       int    `json:"iopub_port"`
-	HBPort          int    `json:"hb_port"`
-	ShellPort       int    `json:"shell_port"`
-	Key             string `json:"key"`
-	IP              string `json:"ip"`
+	conf      *ConnectionInfo
+	signer    *signer
+	transport transport
+	shell     socket
+	control   socket
+	stdin     socket
+	iopub     socket
+	hb        socket
+
+	iopubMu sync.Mutex // iopub is used by both shell and control
+
+	cancelMu sync.Mutex
+	cancel   context.CancelFunc // interrupts the running execution
+
+	vmMu      sync.Mutex // guards the VM, execCount and sources
+	execCount int
+	sources   map[int]cellSource // source code of each segment
+
+	debugger *debugger
+	history  *history
+	comms    *comms
+	deferred []*Message // shell messages received while paused by the comms
+
+	showVM bool // display the VM state after each execution
 }
 
 type Message struct {
@@ -86,49 +93,28 @@ type Message struct {
 	Buffers  [][]byte
 }
 
-func readConf(file string) *ConnectionInfo {
-	c, err := os.ReadFile(file)
+func newSockets(conf *ConnectionInfo, t transport) (*Sockets, error) {
+	sign, err := newSigner(conf.SignatureScheme, conf.Key)
 	if err != nil {
-		panic(err)
-	}
-	var conf ConnectionInfo
-	if err := json.Unmarshal(c, &conf); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return &conf
-}
-
-func bindSocket(typ zmq4.Type, transport, ip string, port int) *zmq4.Socket {
-	sock, err := zmq4.NewSocket(typ)
-	if err != nil {
-		panic(err)
+	s := &Sockets{
+		conf:      conf,
+		signer:    sign,
+		transport: t,
+		sources:   make(map[int]cellSource),
+		history:   &history{session: 1},
 	}
-	sock.Bind(fmt.Sprintf("%s://%s:%d", transport, ip, port))
-	return sock
-}
-
-func newSockets(conf *ConnectionInfo) *Sockets {
-	return &Sockets{
-		conf:    conf,
-		shell:   bindSocket(zmq4.ROUTER, conf.Transport, conf.IP, conf.ShellPort),
-		control: bindSocket(zmq4.ROUTER, conf.Transport, conf.IP, conf.ControlPort),
-		stdin:   bindSocket(zmq4.ROUTER, conf.Transport, conf.IP, conf.StdinPort),
-		iopub:   bindSocket(zmq4.PUB, conf.Transport, conf.IP, conf.IOPubPort),
-		hb:      bindSocket(zmq4.REP, conf.Transport, conf.IP, conf.HBPort),
+	if err := s.bind(); err != nil {
+		return nil, err
 	}
+	s.debugger = newDebugger(s)
+	s.comms = newComms(s)
+	return s, nil
 }
 
-func calcHMAC(key string, header, parent, metadata, content []byte) string {
-	h := hmac.New(sha256.New, []byte(key))
-	h.Write(header)
-	h.Write(parent)
-	h.Write(metadata)
-	h.Write(content)
-	return hex.EncodeToString(h.Sum(nil))
-}
-
-func (s *Sockets) recvRouterMessage(sock *zmq4.Socket) (*Message, error) {
-	mb, err := sock.RecvMessageBytes(0)
+func (s *Sockets) recvRouterMessage(sock socket) (*Message, error) {
+	mb, err := sock.Recv()
 	if err != nil {
 		return nil, err
 	}
@@ -140,7 +126,7 @@ func (s *Sockets) recvRouterMessage(sock *zmq4.Socket) (*Message, error) {
 		}
 	}
 	if d > len(mb)-5 {
-		return nil, fmt.Errorf("invalid message: %v,%v, %v", d, len(mb), mb)
+		return nil, fmt.Errorf("invalid message: %d parts with the delimiter at %d", len(mb), d)
 	}
 
 	msg := &Message{
@@ -153,14 +139,48 @@ func (s *Sockets) recvRouterMessage(sock *zmq4.Socket) (*Message, error) {
 	}
 
 	sig := string(mb[d+1])
-	mac := calcHMAC(s.conf.Key, msg.Header, msg.Parent, msg.Metadata, msg.Content)
-	if sig != mac {
-		return msg, fmt.Errorf("invalid hmac: %v %v", sig, mb)
+	if !s.signer.verify(sig, msg.Header, msg.Parent, msg.Metadata, msg.Content) {
+		// the parts are recorded only in the redacted wire trace.
+		var hdr struct {
+			MsgID   string `json:"msg_id"`
+			MsgType string `json:"msg_type"`
+		}
+		_ = json.Unmarshal(msg.Header, &hdr)
+		return msg, fmt.Errorf("invalid hmac: msg_type=%q msg_id=%q", hdr.MsgType, hdr.MsgID)
 	}
 
 	return msg, nil
 }
 
+// bind binds the sockets to the ports of the connection info.
+// The ports of 0 are replaced with the ports chosen by the kernel.
+func (s *Sockets) bind() error {
+	conf := s.conf
+	if conf.Transport == "ipc" {
+		conf.assignIPCPorts()
+	}
+	socks := []*socket{&s.shell, &s.control, &s.stdin, &s.iopub, &s.hb}
+	types := []socketType{routerSocket, routerSocket, routerSocket, pubSocket, repSocket}
+	for i, p := range conf.ports() {
+		endpoint := conf.endpoint(*p.port)
+		sock, bound, err := s.transport.bind(types[i], endpoint)
+		if err == nil && *p.port == 0 {
+			*p.port, err = boundPort(bound)
+		}
+		if err != nil {
+			for _, sock := range socks[:i] {
+				(*sock).Close()
+			}
+			if sock != nil {
+				sock.Close()
+			}
+			return fmt.Errorf("%s: bind %s: %w", p.name, endpoint, err)
+		}
+		*socks[i] = traceSocket(sock, strings.TrimSuffix(p.name, "_port"))
+	}
+	return nil
+}
+
 func newHeader(msgtype string) []byte {
 	mid, _ := uuid.NewRandom()
 	h := map[string]any{
@@ -175,11 +195,13 @@ func newHeader(msgtype string) []byte {
 	return hdr
 }
 
-func (s *Sockets) send(sock *zmq4.Socket, parent *Message, msgtype string, content []byte) {
+func (s *Sockets) send(sock socket, parent *Message, msgtype string, content []byte) {
 	hdr := newHeader(msgtype)
 	phdr := parent.Header
-	mac := calcHMAC(s.conf.Key, hdr, phdr, metadata, content)
-	_, _ = sock.SendMessage(delimiter, mac, hdr, phdr, metadata, content)
+	mac := s.signer.sign(hdr, phdr, metadata, content)
+	s.iopubMu.Lock()
+	defer s.iopubMu.Unlock()
+	_ = sock.Send([]byte(delimiter), []byte(mac), hdr, phdr, metadata, content)
 }
 
 func (s *Sockets) sendState(parent *Message, state []byte) {
@@ -187,64 +209,99 @@ func (s *Sockets) sendState(parent *Message, state []byte) {
 }
 
 func (s *Sockets) sendStdout(parent *Message, output string) {
-	content, _ := json.Marshal(map[string]string{
-		"name": "stdout",
-		"text": output,
-	})
-	s.send(s.iopub, parent, "stream", content)
+	s.sendStream(parent, "stdout", output)
 }
 
 func (s *Sockets) sendStderr(parent *Message, output string) {
+	s.sendStream(parent, "stderr", output)
+}
+
+func (s *Sockets) sendStream(parent *Message, name, output string) {
 	content, _ := json.Marshal(map[string]string{
-		"name": "stderr",
+		"name": name,
 		"text": output,
 	})
 	s.send(s.iopub, parent, "stream", content)
 }
 
-func (s *Sockets) sendRouter(sock *zmq4.Socket, parent *Message, msgtype string, content []byte) {
+func (s *Sockets) sendRouter(sock socket, parent *Message, msgtype string, content []byte) {
 	hdr := newHeader(msgtype)
 	phdr := parent.Header
-	mac := calcHMAC(s.conf.Key, hdr, phdr, metadata, content)
-	data := make([]any, 0, len(parent.ZmqID)+6)
-	for _, p := range parent.ZmqID {
-		data = append(data, p)
-	}
-	data = append(data, delimiter)
-	data = append(data, mac)
+	mac := s.signer.sign(hdr, phdr, metadata, content)
+	data := make([][]byte, 0, len(parent.ZmqID)+6)
+	data = append(data, parent.ZmqID...)
+	data = append(data, []byte(delimiter))
+	data = append(data, []byte(mac))
 	data = append(data, hdr)
 	data = append(data, phdr)
 	data = append(data, metadata)
 	data = append(data, content)
-	_, _ = sock.SendMessage(data...)
+	_ = sock.Send(data...)
 }
 
-func (s *Sockets) sendCompleteReply(sock *zmq4.Socket, req *Message) {
+func (s *Sockets) sendIsCompleteReply(sock socket, req *Message, vm *wspace.VM) {
 	var content map[string]any
 	_ = json.Unmarshal(req.Content, &content)
-	pos := int(content["cursor_pos"].(float64))
-	rep := fmt.Sprintf(`{"status":"ok","matches":["\t"],"cursor_start":%d,"cursor_end":%d,"metadata":{}}`, pos, pos)
-	s.sendRouter(s.shell, req, "complete_reply", []byte(rep))
+	src, _ := content["code"].(string)
+	_, code := parseMagics([]byte(src))
+
+	rep := map[string]string{"status": "complete"}
+	_, _, err := s.scratchVM(vm, true).Load(code)
+	switch {
+	case errors.Is(err, wspace.ErrIncompleteCode):
+		rep["status"] = "incomplete"
+		rep["indent"] = "" // any indent would be a part of the code
+	case err != nil:
+		rep["status"] = "invalid"
+	}
+	c, _ := json.Marshal(rep)
+	s.sendRouter(sock, req, "is_complete_reply", c)
 }
 
-func (s *Sockets) sendExecuteOKReply(sock *zmq4.Socket, parent *Message, count int) {
+func (s *Sockets) sendExecuteOKReply(sock socket, parent *Message, count int) {
 	content := fmt.Sprintf(`{"status":"ok","execution_count":%d}`, count)
 	s.sendRouter(sock, parent, "execute_reply", []byte(content))
 }
 
-func (s *Sockets) sendExecuteErrorReply(sock *zmq4.Socket, parent *Message, count int, ename, evalue string) {
+func (s *Sockets) sendExecuteInput(parent *Message, code string, count int) {
+	content, _ := json.Marshal(map[string]any{
+		"code":            code,
+		"execution_count": count,
+	})
+	s.send(s.iopub, parent, "execute_input", content)
+}
+
+func (s *Sockets) sendExecuteAbortedReply(sock socket, parent *Message, count int) {
+	content := fmt.Sprintf(`{"status":"aborted","execution_count":%d}`, count)
+	s.sendRouter(sock, parent, "execute_reply", []byte(content))
+}
+
+func (s *Sockets) sendExecuteErrorReply(sock socket, parent *Message, count int, ename, evalue string, traceback []string) {
 	content, _ := json.Marshal(map[string]any{
 		"status":          "error",
 		"execution_count": count,
 		"ename":           ename,
 		"evalue":          evalue,
-		"traceback":       []any{},
+		"traceback":       traceback,
 	})
 	s.sendRouter(sock, parent, "execute_reply", content)
 }
 
-func (s *Sockets) getStdin(parent *Message) ([]byte, error) {
+func (s *Sockets) getStdin(ctx context.Context, parent *Message) ([]byte, error) {
 	s.sendRouter(s.stdin, parent, "input_request", []byte(`{"prompt":"","password":false}`))
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ready, err := s.stdin.Poll(100 * time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+		if ready {
+			break
+		}
+	}
 	msg, err := s.recvRouterMessage(s.stdin)
 	if err != nil {
 		return nil, err
@@ -254,22 +311,28 @@ func (s *Sockets) getStdin(parent *Message) ([]byte, error) {
 	return append([]byte(d["value"]), '\n'), nil
 }
 
+// errStdinNotAllowed is the input error when the frontend does not allow stdin.
+// It is not io.EOF so that the EOF policy of the VM does not hide it.
+var errStdinNotAllowed = errors.New("stdin is not allowed")
+
 type stdinReader struct {
+	ctx    context.Context
 	socks  *Sockets
 	parent *Message
-	stdout *bytes.Buffer
+	stdout *streamWriter
 	buf    []byte
+	allow  bool // allow_stdin of the execute_request
 }
 
 func (i *stdinReader) Read(p []byte) (int, error) {
-	if out := i.stdout.Bytes(); len(out) > 0 {
-		i.socks.sendStdout(i.parent, string(out))
-		i.stdout.Reset()
-	}
+	i.stdout.Flush()
 
 	buf := i.buf
 	if len(buf) == 0 {
-		b, err := i.socks.getStdin(i.parent)
+		if !i.allow {
+			return 0, errStdinNotAllowed
+		}
+		b, err := i.socks.getStdin(i.ctx, i.parent)
 		if err != nil {
 			return 0, err
 		}
@@ -280,11 +343,11 @@ func (i *stdinReader) Read(p []byte) (int, error) {
 	return n, nil
 }
 
+// ReadByte returns the error of Read as it is.
 func (i *stdinReader) ReadByte() (byte, error) {
-	p := make([]byte, 1)
-	n, err := i.Read(p)
-	if n != 1 || err != nil {
-		return 0, fmt.Errorf("ReadByte: n=%v err=%v", n, err)
+	var p [1]byte
+	if _, err := i.Read(p[:]); err != nil {
+		return 0, err
 	}
 	return p[0], nil
 }
@@ -294,20 +357,30 @@ func lineNum(code []byte, pos int) int {
 }
 
 func (s *Sockets) shellHandler(vm *wspace.VM) {
-	execCount := 0
+	// after an error with stop_on_error, the queued execute_requests are aborted.
+	aborting := false
 	for {
-		msg, err := s.recvRouterMessage(s.shell)
+		if aborting && len(s.deferred) == 0 && !pending(s.shell) {
+			aborting = false
+		}
+		msg, err := s.nextShellMessage()
 		if err != nil {
-			log.Printf("shell: recv: %v", err)
+			if err == errTerminated {
+				s.shell.Close()
+				s.stdin.Close()
+				s.closeIOPub()
+				return
+			}
+			slog.Warn("recv", "socket", "shell", "err", err)
 			continue
 		}
 		var hdr map[string]any
 		if err := json.Unmarshal(msg.Header, &hdr); err != nil {
-			log.Printf("shell: header: %v", err)
+			slog.Warn("invalid header", "socket", "shell", "err", err)
 			continue
 		}
 
-		log.Println("shell:", hdr["msg_type"], string(msg.Content))
+		slog.Debug("message", "socket", "shell", "msg_type", hdr["msg_type"], "msg_id", hdr["msg_id"])
 		switch hdr["msg_type"] {
 
 		case "kernel_info_request":
@@ -316,85 +389,407 @@ func (s *Sockets) shellHandler(vm *wspace.VM) {
 
 		case "complete_request":
 			s.sendState(msg, stateBusy)
-			s.sendCompleteReply(s.shell, msg)
+			s.sendCompleteReply(s.shell, msg, vm)
 			s.sendState(msg, stateIdle)
 
-		case "execute_request":
+		case "inspect_request":
 			s.sendState(msg, stateBusy)
-			execCount++
+			s.sendInspectReply(s.shell, msg, vm)
+			s.sendState(msg, stateIdle)
 
-			vm.PC = len(vm.Program)
-			vm.Terminated = false
+		case "comm_open", "comm_msg", "comm_close", "comm_info_request":
+			s.sendState(msg, stateBusy)
+			s.handleComm(vm, msg, hdr["msg_type"].(string))
+			s.sendState(msg, stateIdle)
 
-			var content map[string]any
-			_ = json.Unmarshal(msg.Content, &content)
-			code := []byte(content["code"].(string))
-			_, pos, err := vm.Load(code)
-			if err != nil {
-				s.sendStderr(msg, fmt.Sprintf("%v: %v", lineNum(code, pos), err.Error()))
-				s.sendExecuteErrorReply(s.shell, msg, execCount, "LoadingError", err.Error())
-				s.sendState(msg, stateIdle)
-				continue
-			}
+		case "history_request":
+			s.sendState(msg, stateBusy)
+			s.sendHistoryReply(s.shell, msg)
+			s.sendState(msg, stateIdle)
 
-			out := new(bytes.Buffer)
-			in := &stdinReader{socks: s, parent: msg, stdout: out}
-			err = vm.Run(context.Background(), in, out)
-			if len(out.Bytes()) > 0 {
-				s.sendStdout(msg, string(out.Bytes()))
-			}
-			if err != nil {
-				op := vm.CurrentOpCode()
-				s.sendStderr(msg, fmt.Sprintf("%v: %v: %v", lineNum(code, op.Pos), op.Cmd, err.Error()))
-				s.sendExecuteErrorReply(s.shell, msg, execCount, "RuntimeError", err.Error())
-				s.sendState(msg, stateIdle)
-				continue
-			}
+		case "is_complete_request":
+			s.sendState(msg, stateBusy)
+			s.sendIsCompleteReply(s.shell, msg, vm)
+			s.sendState(msg, stateIdle)
 
-			s.sendExecuteOKReply(s.shell, msg, execCount)
+		case "execute_request":
+			s.sendState(msg, stateBusy)
+			req := parseExecuteRequest(msg)
+			if aborting {
+				s.sendExecuteAbortedReply(s.shell, msg, s.executionCount())
+			} else if !s.execute(vm, msg, req) && req.StopOnError {
+				aborting = true
+			}
 			s.sendState(msg, stateIdle)
 		}
 	}
 }
 
-func (s *Sockets) controlHandler(shutdown chan<- struct{}) {
+// executionCount returns the current execution count.
+func (s *Sockets) executionCount() int {
+	s.vmMu.Lock()
+	defer s.vmMu.Unlock()
+	return s.execCount
+}
+
+// nextShellMessage returns the deferred message or receives a new one.
+func (s *Sockets) nextShellMessage() (*Message, error) {
+	if len(s.deferred) > 0 {
+		msg := s.deferred[0]
+		s.deferred = s.deferred[1:]
+		return msg, nil
+	}
+	return s.recvRouterMessage(s.shell)
+}
+
+// pumpShell waits for a shell message up to the timeout and handles it if it is a comm message.
+// The other messages are deferred until the execution finishes.
+func (s *Sockets) pumpShell(ctx context.Context, vm *wspace.VM, timeout time.Duration) error {
+	if ctx.Err() != nil {
+		return wspace.ErrContextDone
+	}
+	if !poll(s.shell, timeout) {
+		return nil
+	}
+	msg, err := s.recvRouterMessage(s.shell)
+	if err != nil {
+		slog.Warn("recv", "socket", "shell", "err", err)
+		return nil
+	}
+	var hdr struct {
+		MsgType string `json:"msg_type"`
+	}
+	_ = json.Unmarshal(msg.Header, &hdr)
+	switch hdr.MsgType {
+	case "comm_open", "comm_msg", "comm_close", "comm_info_request":
+		s.handleComm(vm, msg, hdr.MsgType)
+	default:
+		s.deferred = append(s.deferred, msg)
+	}
+	return nil
+}
+
+// executeRequest is the content of execute_request.
+type executeRequest struct {
+	Code         string `json:"code"`
+	Silent       bool   `json:"silent"`
+	StoreHistory bool   `json:"store_history"`
+	AllowStdin   bool   `json:"allow_stdin"`
+	StopOnError  bool   `json:"stop_on_error"`
+}
+
+func parseExecuteRequest(msg *Message) *executeRequest {
+	req := &executeRequest{
+		StoreHistory: true,
+		AllowStdin:   true,
+		StopOnError:  true,
+	}
+	_ = json.Unmarshal(msg.Content, req)
+	if req.Silent {
+		req.StoreHistory = false
+	}
+	return req
+}
+
+// execute executes the code and reports whether it succeeded.
+func (s *Sockets) execute(vm *wspace.VM, msg *Message, req *executeRequest) bool {
+	s.vmMu.Lock()
+	defer s.vmMu.Unlock()
+
+	if req.StoreHistory {
+		s.execCount++
+		s.history.add(s.execCount, req.Code)
+	}
+	if !req.Silent {
+		s.sendExecuteInput(msg, req.Code, s.execCount)
+	}
+
+	src := []byte(req.Code)
+	magics, code := parseMagics(src)
+	off := len(src) - len(code)
+	trace := s.runMagics(vm, msg, magics, req.Silent)
+
+	vm.PC = len(vm.Program)
+	vm.Terminated = false
+
+	seg, pos, err := vm.Load(code)
+	s.sources[seg] = cellSource{src: src, off: off, path: sourcePath(src)}
+	if err != nil {
+		s.sendErrorReply(vm, msg, req, "LoadingError", err.Error(), seg, pos, 0)
+		return false
+	}
+
+	var heap map[int]int
+	if s.showVM {
+		heap = make(map[int]int, len(vm.Heap))
+		for a, v := range vm.Heap {
+			heap[a] = v
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.setCancel(cancel)
+	out := newStreamWriter(s, msg, "stdout", req.Silent)
+	in := &stdinReader{ctx: ctx, socks: s, parent: msg, stdout: out, allow: req.AllowStdin}
+	traceOut := newStreamWriter(s, msg, "stderr", req.Silent)
+	if trace {
+		vm.Tracing = true
+		vm.Debug = traceOut
+	}
+	if s.debugger.active() {
+		err = s.debugger.run(ctx, vm, msg, in, out)
+	} else if s.comms.active() {
+		err = s.runInspected(ctx, vm, msg, in, out)
+	} else {
+		err = vm.Run(ctx, in, out)
+	}
+	s.setCancel(nil)
+	interrupted := ctx.Err() != nil
+	cancel()
+	if trace {
+		vm.Tracing = false
+		vm.Debug = nil
+	}
+	traceOut.Close()
+	out.Close()
+	if s.showVM && !req.Silent && (interrupted || err != nil) {
+		s.sendDisplayData(msg, newVMState(vm, heap).data())
+	}
+	if interrupted || err != nil {
+		ename, evalue := "RuntimeError", fmt.Sprint(err)
+		if interrupted {
+			ename, evalue = "KeyboardInterrupt", "interrupted"
+		}
+		if op := vm.CurrentOpCode(); op != nil {
+			s.sendErrorReply(vm, msg, req, ename, evalue, op.Seg, op.Pos, op.Cmd)
+		} else {
+			s.sendErrorReply(vm, msg, req, ename, evalue, 0, 0, 0)
+		}
+		return false
+	}
+
+	if s.showVM && !req.Silent {
+		s.sendExecuteResult(msg, s.execCount, newVMState(vm, heap))
+	}
+	s.sendExecuteOKReply(s.shell, msg, s.execCount)
+	return true
+}
+
+// sendErrorReply publishes the error with the traceback unless the request is silent
+// and replies to the execute_request.
+func (s *Sockets) sendErrorReply(vm *wspace.VM, msg *Message, req *executeRequest, ename, evalue string, seg, pos int, cmd wspace.Command) {
+	tb := s.traceback(vm, ename, evalue, seg, pos, cmd)
+	if !req.Silent {
+		s.sendError(msg, ename, evalue, tb)
+	}
+	s.sendExecuteErrorReply(s.shell, msg, s.execCount, ename, evalue, tb)
+}
+
+func (s *Sockets) controlHandler(vm *wspace.VM, shutdown chan<- struct{}) {
 	for {
 		msg, err := s.recvRouterMessage(s.control)
 		if err != nil {
-			log.Printf("control: recv: %v", err)
+			if err == errTerminated {
+				s.control.Close()
+				return
+			}
+			slog.Warn("recv", "socket", "control", "err", err)
 			continue
 		}
 		var hdr map[string]any
 		if err := json.Unmarshal(msg.Header, &hdr); err != nil {
-			log.Printf("control: header: %v", err)
+			slog.Warn("invalid header", "socket", "control", "err", err)
 			continue
 		}
 
-		log.Println("control:", hdr["msg_type"], string(msg.Content))
+		slog.Debug("message", "socket", "control", "msg_type", hdr["msg_type"], "msg_id", hdr["msg_id"])
 		switch hdr["msg_type"] {
 		case "shutdown_request":
-			shutdown <- struct{}{}
+			var content struct {
+				Restart bool `json:"restart"`
+			}
+			_ = json.Unmarshal(msg.Content, &content)
+
+			s.sendState(msg, stateBusy)
+			if content.Restart {
+				s.restart(vm)
+			}
+			rep, _ := json.Marshal(map[string]any{
+				"status":  "ok",
+				"restart": content.Restart,
+			})
+			s.sendRouter(s.control, msg, "shutdown_reply", rep)
+			s.sendState(msg, stateIdle)
+			if !content.Restart {
+				shutdown <- struct{}{}
+			}
+
+		case "interrupt_request":
+			s.sendState(msg, stateBusy)
+			s.interrupt()
+			s.sendRouter(s.control, msg, "interrupt_reply", []byte(`{"status":"ok"}`))
+			s.sendState(msg, stateIdle)
+
+		case "debug_request":
+			s.sendState(msg, stateBusy)
+			s.debugger.handle(vm, msg)
+			s.sendState(msg, stateIdle)
 		}
 	}
 }
 
+// pending reports whether the socket has a message to be received.
+func pending(sock socket) bool {
+	return poll(sock, 0)
+}
+
+func poll(sock socket, timeout time.Duration) bool {
+	ready, err := sock.Poll(timeout)
+	return err == nil && ready
+}
+
+func (s *Sockets) setCancel(cancel context.CancelFunc) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	s.cancel = cancel
+}
+
+func (s *Sockets) interrupt() {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// restart resets the VM and the execution count in place.
+// The comms and the debugger are also reset as the frontend drops them on restart.
+func (s *Sockets) restart(vm *wspace.VM) {
+	s.interrupt()
+	s.vmMu.Lock()
+	defer s.vmMu.Unlock()
+	*vm = *newVM()
+	s.execCount = 0
+	s.sources = make(map[int]cellSource)
+	s.history.newSession()
+	s.comms.reset()
+	s.debugger.reset()
+}
+
 func (s *Sockets) hbHandler() {
-	zmq4.Proxy(s.hb, s.hb, nil)
+	for {
+		msg, err := s.hb.Recv()
+		if err == nil {
+			err = s.hb.Send(msg...)
+		}
+		if err == errTerminated {
+			s.hb.Close()
+			return
+		}
+	}
+}
+
+func (s *Sockets) closeIOPub() {
+	s.iopubMu.Lock()
+	defer s.iopubMu.Unlock()
+	s.iopub.Close()
+}
+
+// close stops the running execution and terminates the sockets.
+// Each handler closes its sockets when the context is terminated.
+func (s *Sockets) close() {
+	s.interrupt()
+	done := make(chan struct{})
+	go func() {
+		_ = s.transport.term()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		slog.Warn("close: timeout")
+	}
+}
+
+// scratchVM returns a new VM which has the same settings (and labels) as vm
+// to parse the code without changing vm.
+func (s *Sockets) scratchVM(vm *wspace.VM, labels bool) *wspace.VM {
+	s.vmMu.Lock()
+	defer s.vmMu.Unlock()
+	v := newVM()
+	v.Dialect = vm.Dialect
+	v.Extensions = vm.Extensions
+	if labels {
+		for l, p := range vm.Labels {
+			v.Labels[l] = p
+		}
+	}
+	return v
+}
+
+func newVM() *wspace.VM {
+	vm := wspace.New()
+	vm.Encoding = wspace.UTF8
+	return vm
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Println("need connection file")
+	showVM := flag.Bool("show-vm", false, "display the VM state after each execution")
+	historyFile := flag.String("history-file", "", "store the history of the executed cells in the JSON-lines file")
+	logLevel := flag.String("log-level", getenv("WHITENOTE_LOG_LEVEL", "info"), "log level: trace, debug, info, warn or error (trace records every message on the wire)")
+	logFile := flag.String("log-file", getenv("WHITENOTE_LOG_FILE", ""), "write the log to the file instead of stderr")
+	flag.Parse()
+	closeLog, err := setupLogger(*logLevel, *logFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "log:", err)
+		os.Exit(2)
+	}
+	defer closeLog()
+	if flag.NArg() < 1 {
+		slog.Error("need connection file")
 		return
 	}
-	conf := readConf(os.Args[1])
-	socks := newSockets(conf)
+	if err := run(flag.Arg(0), *showVM, *historyFile); err != nil {
+		slog.Error(err.Error())
+		closeLog()
+		os.Exit(1)
+	}
+}
 
-	vm := wspace.New()
+func run(file string, showVM bool, historyFile string) error {
+	conf, err := readConf(file)
+	if err != nil {
+		return err
+	}
+	hist := &history{session: 1}
+	if historyFile != "" {
+		hist, err = newHistory(historyFile)
+		if err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+		defer hist.close()
+	}
+	assign := conf.hasUnassignedPort()
+	socks, err := newSockets(conf, newTransport())
+	if err != nil {
+		return err
+	}
+	if assign {
+		if err := writePorts(file, conf); err != nil {
+			return fmt.Errorf("connection file: %w", err)
+		}
+	}
+	slog.Info("whitenote started", "transport", conf.Transport, "ip", conf.IP,
+		"shell_port", conf.ShellPort, "control_port", conf.ControlPort, "stdin_port", conf.StdinPort,
+		"iopub_port", conf.IOPubPort, "hb_port", conf.HBPort)
+	socks.showVM = showVM
+	socks.history = hist
+
+	vm := newVM()
 	shutdown := make(chan struct{}, 1)
 
 	go socks.shellHandler(vm)
-	go socks.controlHandler(shutdown)
+	go socks.controlHandler(vm, shutdown)
 	go socks.hbHandler()
 
 	sig := make(chan os.Signal, 1)
@@ -404,5 +799,7 @@ func main() {
 	case <-sig:
 	case <-shutdown:
 	}
-	log.Println("whitenote shuting down...")
+	slog.Info("whitenote shutting down")
+	socks.close()
+	return nil
 }
@@ -3,6 +3,18 @@
 // Usage:
 //   wspace <file>
 //     Evaluate the file
+//   wspace minify <file>
+//     Output the minified code of the file
+//   wspace embed <file> <cover>
+//     Output the cover text hiding the code of the file
+//   wspace extract <file>
+//     Output the code hidden in the file
+//   wspace verify <file> <code>
+//     Verify the file hides the code
+//   wspace dap [<addr>]
+//     Serve the Debug Adapter Protocol on stdio, or on TCP at the address
+//   wspace lsp
+//     Serve the Language Server Protocol on stdio
 //   wspace
 //     Launch an interactive interpreter
 //
@@ -15,12 +27,35 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 
 	"github.com/makiuchi-d/whitenote/wspace"
+	"github.com/makiuchi-d/whitenote/wspace/dap"
+	"github.com/makiuchi-d/whitenote/wspace/lsp"
 )
 
 func main() {
+	switch {
+	case len(os.Args) >= 3 && os.Args[1] == "minify":
+		minifyFile(os.Args[2])
+		return
+	case len(os.Args) >= 4 && os.Args[1] == "embed":
+		embedFile(os.Args[2], os.Args[3])
+		return
+	case len(os.Args) >= 3 && os.Args[1] == "extract":
+		extractFile(os.Args[2])
+		return
+	case len(os.Args) >= 4 && os.Args[1] == "verify":
+		verifyFile(os.Args[2], os.Args[3])
+		return
+	case len(os.Args) >= 2 && os.Args[1] == "dap":
+		serveDAP(os.Args[2:])
+		return
+	case len(os.Args) >= 2 && os.Args[1] == "lsp":
+		serveLSP()
+		return
+	}
 	if len(os.Args) >= 2 {
 		evalFile(os.Args[1])
 		return
@@ -56,6 +91,102 @@ func evalFile(fname string) {
 	}
 }
 
+func minifyFile(fname string) {
+	code := readFile(fname)
+
+	vm := wspace.New()
+
+	_, p, err := vm.Load(code)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s:%v: %+v\n", fname, p, err)
+		os.Exit(-1)
+	}
+
+	out := wspace.Encode(wspace.Minify(vm.Program))
+	os.Stdout.Write(out)
+
+	fmt.Fprintf(os.Stderr, "%s: %v -> %v bytes\n", fname, len(code), len(out))
+}
+
+func embedFile(fname, cover string) {
+	code := readFile(fname)
+	text, err := wspace.Embed(code, readFile(cover))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %+v\n", fname, err)
+		os.Exit(-1)
+	}
+	os.Stdout.Write(text)
+}
+
+func extractFile(fname string) {
+	code, _, err := wspace.Extract(readFile(fname))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %+v\n", fname, err)
+		os.Exit(-1)
+	}
+	os.Stdout.Write(code)
+}
+
+func verifyFile(fname, code string) {
+	err := wspace.Verify(readFile(fname), readFile(code))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %+v\n", fname, err)
+		os.Exit(-1)
+	}
+	fmt.Fprintf(os.Stderr, "%s: ok\n", fname)
+}
+
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+func serveDAP(args []string) {
+	if len(args) == 0 {
+		if err := dap.Serve(stdio{os.Stdin, os.Stdout}); err != nil {
+			fmt.Fprintf(os.Stderr, "%+v\n", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
+	l, err := net.Listen("tcp", args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(-1)
+	}
+	fmt.Fprintf(os.Stderr, "listening on %v\n", l.Addr())
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%+v\n", err)
+			os.Exit(-1)
+		}
+		go func() {
+			defer c.Close()
+			if err := dap.Serve(c); err != nil {
+				fmt.Fprintf(os.Stderr, "%v: %+v\n", c.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func serveLSP() {
+	if err := lsp.Serve(stdio{os.Stdin, os.Stdout}); err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(-1)
+	}
+}
+
+func readFile(fname string) []byte {
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(-1)
+	}
+	return b
+}
+
 func interactive() {
 	vm := wspace.New()
 
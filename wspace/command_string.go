@@ -32,11 +32,14 @@ func _() {
 	_ = x[WriteNum-22]
 	_ = x[ReadChar-23]
 	_ = x[ReadNum-24]
+	_ = x[DebugStack-25]
+	_ = x[DebugHeap-26]
+	_ = x[Trace-27]
 }
 
-const _Command_name = "PushDupCopySwapDiscardSlideAddSubMulDivModStoreRetrieveMarkCallJumpJZeroJNegRetEndWriteCharWriteNumReadCharReadNum"
+const _Command_name = "PushDupCopySwapDiscardSlideAddSubMulDivModStoreRetrieveMarkCallJumpJZeroJNegRetEndWriteCharWriteNumReadCharReadNumDebugStackDebugHeapTrace"
 
-var _Command_index = [...]uint8{0, 4, 7, 11, 15, 22, 27, 30, 33, 36, 39, 42, 47, 55, 59, 63, 67, 72, 76, 79, 82, 91, 99, 107, 114}
+var _Command_index = [...]uint8{0, 4, 7, 11, 15, 22, 27, 30, 33, 36, 39, 42, 47, 55, 59, 63, 67, 72, 76, 79, 82, 91, 99, 107, 114, 124, 133, 138}
 
 func (i Command) String() string {
 	i -= 1
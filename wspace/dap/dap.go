@@ -0,0 +1,101 @@
+// dap package provides a [Debug Adapter Protocol] server for the whitespace VM.
+//
+// [Debug Adapter Protocol]: https://microsoft.github.io/debug-adapter-protocol/
+package dap
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/makiuchi-d/whitenote/wspace/internal/frame"
+)
+
+// request is a request of the protocol.
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type response struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+// conn reads and writes the messages with the Content-Length header.
+type conn struct {
+	r *frame.Reader
+
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{
+		r: frame.NewReader(rw),
+		w: rw,
+	}
+}
+
+func (c *conn) read() (*request, error) {
+	body, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// write writes the message numbering its seq.
+func (c *conn) write(seq *int, msg any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	*seq = c.seq
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return frame.Write(c.w, body)
+}
+
+func (c *conn) respond(req *request, body any, err error) error {
+	rep := &response{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		rep.Message = err.Error()
+	}
+	return c.write(&rep.Seq, rep)
+}
+
+func (c *conn) event(name string, body any) error {
+	ev := &event{
+		Type:  "event",
+		Event: name,
+		Body:  body,
+	}
+	return c.write(&ev.Seq, ev)
+}
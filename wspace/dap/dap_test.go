@@ -0,0 +1,268 @@
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+	"github.com/makiuchi-d/whitenote/wspace/debug"
+)
+
+// client is a scripted DAP client.
+type client struct {
+	t       *testing.T
+	conn    net.Conn
+	msgs    chan map[string]any
+	pending []map[string]any // events received while waiting for a response
+	seq     int
+}
+
+func newClient(t *testing.T) *client {
+	sc, cc := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(sc)
+		sc.Close()
+	}()
+
+	c := &client{t: t, conn: cc, msgs: make(chan map[string]any, 100)}
+	go func() {
+		defer close(c.msgs)
+		r := textproto.NewReader(bufio.NewReader(cc))
+		for {
+			hdr, err := r.ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(hdr.Get("Content-Length"))
+			body := make([]byte, n)
+			if _, err := io.ReadFull(r.R, body); err != nil {
+				return
+			}
+			var m map[string]any
+			if err := json.Unmarshal(body, &m); err != nil {
+				t.Errorf("invalid message: %v: %s", err, body)
+				return
+			}
+			c.msgs <- m
+		}
+	}()
+	t.Cleanup(func() {
+		cc.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return c
+}
+
+// next returns the next message including the pending events.
+func (c *client) next() map[string]any {
+	c.t.Helper()
+	if len(c.pending) > 0 {
+		m := c.pending[0]
+		c.pending = c.pending[1:]
+		return m
+	}
+	return c.recv()
+}
+
+func (c *client) recv() map[string]any {
+	c.t.Helper()
+	select {
+	case m, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatalf("connection closed")
+		}
+		return m
+	case <-time.After(3 * time.Second):
+		c.t.Fatalf("timeout")
+	}
+	return nil
+}
+
+// call sends the request and returns the body of the response.
+func (c *client) call(command string, args any) map[string]any {
+	c.t.Helper()
+	c.seq++
+	body, _ := json.Marshal(map[string]any{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	if _, err := c.conn.Write(append([]byte("Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"), body...)); err != nil {
+		c.t.Fatalf("%v: write: %v", command, err)
+	}
+	for {
+		m := c.recv()
+		if m["type"] == "event" {
+			c.pending = append(c.pending, m)
+			continue
+		}
+		if m["request_seq"] != float64(c.seq) || m["command"] != command {
+			c.t.Fatalf("%v: unexpected response: %v", command, m)
+		}
+		if m["success"] != true {
+			c.t.Fatalf("%v: failed: %v", command, m["message"])
+		}
+		b, _ := m["body"].(map[string]any)
+		return b
+	}
+}
+
+// event waits for the event and returns its body.
+func (c *client) event(name string) map[string]any {
+	c.t.Helper()
+	for {
+		m := c.next()
+		if m["type"] == "event" && m["event"] == name {
+			b, _ := m["body"].(map[string]any)
+			return b
+		}
+		if m["event"] == "output" || m["event"] == "initialized" {
+			continue
+		}
+		c.t.Fatalf("waiting %v: unexpected message: %v", name, m)
+	}
+}
+
+func writeProgram(t *testing.T, src string) string {
+	path := filepath.Join(t.TempDir(), "test.ws")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func opLine(t *testing.T, src string, cmd wspace.Command) int {
+	vm := wspace.New()
+	if _, _, err := vm.Load([]byte(src)); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range vm.Program {
+		if op.Cmd == cmd {
+			return bytes.Count([]byte(src[:op.Pos]), []byte{'\n'}) + 1
+		}
+	}
+	t.Fatalf("%v not found", cmd)
+	return 0
+}
+
+func TestServe(t *testing.T) {
+	// push 1; push 2; call A; outnum; end; A: add; return
+	src := "push1   \t\n" +
+		"push2   \t \n" +
+		"callA\n \t \n" +
+		"outnum\t\n \t" +
+		"end\n\n\n" +
+		"markA\n   \n" +
+		"add\t   " +
+		"return\n\t\n"
+	path := writeProgram(t, src)
+	addLine := opLine(t, src, wspace.Add)
+
+	c := newClient(t)
+	c.call("initialize", map[string]any{"adapterID": "wspace"})
+	c.event("initialized")
+	c.call("launch", map[string]any{"program": path})
+
+	b := c.call("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": path},
+		"breakpoints": []map[string]any{{"line": addLine}},
+	})
+	bp := b["breakpoints"].([]any)[0].(map[string]any)
+	if bp["verified"] != true || bp["line"] != float64(addLine) {
+		t.Fatalf("breakpoint: %v, wants verified at line %v", bp, addLine)
+	}
+
+	c.call("configurationDone", nil)
+	if ev := c.event("stopped"); ev["reason"] != "breakpoint" {
+		t.Fatalf("stopped: %v", ev)
+	}
+
+	b = c.call("stackTrace", map[string]any{"threadId": debug.ThreadID})
+	frames := b["stackFrames"].([]any)
+	if len(frames) != 2 {
+		t.Fatalf("stackFrames: %v", frames)
+	}
+	f0 := frames[0].(map[string]any)
+	f1 := frames[1].(map[string]any)
+	if f0["name"] != ".: Add" || f0["line"] != float64(addLine) {
+		t.Fatalf("frame 0: %v", f0)
+	}
+	if f1["name"] != "(main): Call" {
+		t.Fatalf("frame 1: %v", f1)
+	}
+
+	b = c.call("variables", map[string]any{"variablesReference": debug.RefStack})
+	vars := b["variables"].([]any)
+	if len(vars) != 2 || vars[0].(map[string]any)["value"] != "2" || vars[1].(map[string]any)["value"] != "1" {
+		t.Fatalf("stack variables: %v", vars)
+	}
+
+	c.call("stepOut", map[string]any{"threadId": debug.ThreadID})
+	if ev := c.event("stopped"); ev["reason"] != "step" {
+		t.Fatalf("stopped: %v", ev)
+	}
+	b = c.call("stackTrace", map[string]any{"threadId": debug.ThreadID})
+	frames = b["stackFrames"].([]any)
+	if len(frames) != 1 || frames[0].(map[string]any)["name"] != "(main): WriteNum" {
+		t.Fatalf("stackFrames: %v", frames)
+	}
+
+	c.call("continue", map[string]any{"threadId": debug.ThreadID})
+	out := ""
+	for {
+		m := c.next()
+		if m["event"] == "output" {
+			out += m["body"].(map[string]any)["output"].(string)
+			continue
+		}
+		if m["event"] != "exited" {
+			t.Fatalf("unexpected message: %v", m)
+		}
+		if code := m["body"].(map[string]any)["exitCode"]; code != float64(0) {
+			t.Fatalf("exitCode=%v", code)
+		}
+		break
+	}
+	if out != "3" {
+		t.Fatalf("output=%q, wants %q", out, "3")
+	}
+	c.event("terminated")
+	c.call("disconnect", nil)
+}
+
+func TestServeError(t *testing.T) {
+	// discard on the empty stack
+	path := writeProgram(t, " \n\n")
+
+	c := newClient(t)
+	c.call("initialize", nil)
+	c.call("launch", map[string]any{"program": path, "stopOnEntry": true})
+	c.call("configurationDone", nil)
+	if ev := c.event("stopped"); ev["reason"] != "entry" {
+		t.Fatalf("stopped: %v", ev)
+	}
+	c.call("continue", map[string]any{"threadId": debug.ThreadID})
+
+	m := c.next()
+	if m["event"] != "output" || m["body"].(map[string]any)["category"] != "stderr" {
+		t.Fatalf("unexpected message: %v", m)
+	}
+	if ev := c.event("exited"); ev["exitCode"] != float64(1) {
+		t.Fatalf("exited: %v", ev)
+	}
+	c.event("terminated")
+	c.call("disconnect", nil)
+}
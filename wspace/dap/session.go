@@ -0,0 +1,424 @@
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+	"github.com/makiuchi-d/whitenote/wspace/debug"
+)
+
+var (
+	errNotLaunched = errors.New("program is not launched")
+	errDisconnect  = errors.New("disconnected")
+)
+
+// session is a debug session of a program.
+type session struct {
+	conn *conn
+	dbg  *debug.Session
+	out  *outputWriter // output of the running program
+
+	lineBase int // 1 if lines start at 1
+	colBase  int // 1 if columns start at 1
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu          sync.Mutex
+	vm          *wspace.VM
+	path        string
+	src         []byte
+	lineStarts  []int            // byte offsets of the lines
+	bpLines     map[string][]int // requested lines of each source path
+	breakpoints map[int]bool     // PCs of the breakpoints
+	input       *bufio.Reader    // stdin of the program
+	launched    bool
+	configured  bool
+	running     bool
+}
+
+// Serve runs a debug session on the connection until the client disconnects.
+// The program is specified by the "program" argument of the launch request,
+// and the "input" argument is given to the program as stdin.
+func Serve(rw io.ReadWriter) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &session{
+		conn:        newConn(rw),
+		lineBase:    1,
+		colBase:     1,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		bpLines:     make(map[string][]int),
+		breakpoints: make(map[int]bool),
+	}
+	s.dbg = debug.NewSession(s)
+	defer s.shutdown()
+
+	for {
+		req, err := s.conn.read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := s.handle(req); err != nil {
+			if err == errDisconnect {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// shutdown stops the running program and waits for it.
+func (s *session) shutdown() {
+	s.mu.Lock()
+	running := s.running
+	s.cancel()
+	s.mu.Unlock()
+	if running {
+		<-s.done
+	}
+}
+
+func (s *session) handle(req *request) error {
+	var body any
+	var err error
+	switch req.Command {
+	case "initialize":
+		body, err = s.initialize(req.Arguments)
+		if err := s.conn.respond(req, body, err); err != nil {
+			return err
+		}
+		return s.conn.event("initialized", nil)
+	case "launch":
+		err = s.launch(req.Arguments)
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req.Arguments)
+	case "configurationDone":
+		s.mu.Lock()
+		s.configured = true
+		s.mu.Unlock()
+	case "threads":
+		body = map[string]any{
+			"threads": []map[string]any{{"id": debug.ThreadID, "name": "main"}},
+		}
+	case "continue":
+		err = s.dbg.Continue(debug.StepNone)
+		body = map[string]any{"allThreadsContinued": true}
+	case "next":
+		err = s.dbg.Continue(debug.StepOver)
+	case "stepIn":
+		err = s.dbg.Continue(debug.StepIn)
+	case "stepOut":
+		err = s.dbg.Continue(debug.StepOut)
+	case "pause":
+		s.dbg.Pause()
+	case "stackTrace":
+		if err = s.checkLaunched(); err == nil {
+			body, err = s.dbg.StackTrace()
+		}
+	case "scopes":
+		body = debug.Scopes()
+	case "variables":
+		body, err = s.variables(req.Arguments)
+	case "disconnect", "terminate":
+		s.shutdown()
+		if err := s.conn.respond(req, nil, nil); err != nil {
+			return err
+		}
+		if req.Command == "terminate" {
+			return nil
+		}
+		return errDisconnect
+	default:
+		err = fmt.Errorf("unsupported command: %v", req.Command)
+	}
+	if err := s.conn.respond(req, body, err); err != nil {
+		return err
+	}
+	if req.Command == "launch" || req.Command == "configurationDone" {
+		s.start()
+	}
+	return nil
+}
+
+func (s *session) initialize(args json.RawMessage) (any, error) {
+	var a struct {
+		LinesStartAt1   *bool `json:"linesStartAt1"`
+		ColumnsStartAt1 *bool `json:"columnsStartAt1"`
+	}
+	if len(args) > 0 {
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+	}
+	if a.LinesStartAt1 != nil && !*a.LinesStartAt1 {
+		s.lineBase = 0
+	}
+	if a.ColumnsStartAt1 != nil && !*a.ColumnsStartAt1 {
+		s.colBase = 0
+	}
+	return map[string]any{
+		"supportsConfigurationDoneRequest": true,
+		"supportsTerminateRequest":         true,
+	}, nil
+}
+
+func (s *session) launch(args json.RawMessage) error {
+	var a struct {
+		Program     string `json:"program"`
+		Input       string `json:"input"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return err
+	}
+	src, err := os.ReadFile(a.Program)
+	if err != nil {
+		return err
+	}
+	vm := wspace.New()
+	if _, p, err := vm.Load(src); err != nil {
+		return fmt.Errorf("%s:%v: %w", a.Program, p, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vm = vm
+	s.path = a.Program
+	s.src = src
+	s.lineStarts = lineStarts(src)
+	s.input = bufio.NewReader(strings.NewReader(a.Input))
+	s.launched = true
+	s.resolveBreakpoints()
+	if a.StopOnEntry {
+		s.dbg.StopOnEntry()
+	}
+	return nil
+}
+
+func (s *session) checkLaunched() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.launched {
+		return errNotLaunched
+	}
+	return nil
+}
+
+// lineStarts returns the byte offsets of the lines.
+func lineStarts(src []byte) []int {
+	ls := []int{0}
+	for i, c := range src {
+		if c == '\n' {
+			ls = append(ls, i+1)
+		}
+	}
+	return ls
+}
+
+// position returns the 1-based line and column of the byte offset in the source.
+func (s *session) position(pos int) (int, int) {
+	l := sort.Search(len(s.lineStarts), func(i int) bool { return s.lineStarts[i] > pos })
+	start := s.lineStarts[l-1]
+	return l, utf8.RuneCount(s.src[start:pos]) + 1
+}
+
+func (s *session) setBreakpoints(args json.RawMessage) (any, error) {
+	var a struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	lines := make([]int, 0, len(a.Breakpoints))
+	for _, b := range a.Breakpoints {
+		lines = append(lines, b.Line-s.lineBase+1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bpLines[a.Source.Path] = lines
+	pcs := s.resolveBreakpoints()
+
+	bps := make([]map[string]any, 0, len(lines))
+	for _, l := range lines {
+		pc, ok := pcs[l]
+		if !ok || !s.launched || !samePath(a.Source.Path, s.path) {
+			bps = append(bps, map[string]any{"verified": false, "line": l - 1 + s.lineBase})
+			continue
+		}
+		line, col := s.position(s.vm.Program[pc].Pos)
+		bps = append(bps, map[string]any{
+			"verified": true,
+			"line":     line - 1 + s.lineBase,
+			"column":   col - 1 + s.colBase,
+		})
+	}
+	return map[string]any{"breakpoints": bps}, nil
+}
+
+// resolveBreakpoints maps the requested lines of the program to the PCs.
+// A breakpoint is placed on the first opcode starting at the line or after the line.
+// s.mu must be locked.
+func (s *session) resolveBreakpoints() map[int]int {
+	s.breakpoints = make(map[int]bool)
+	pcs := make(map[int]int)
+	if !s.launched {
+		return pcs
+	}
+	for path, lines := range s.bpLines {
+		if !samePath(path, s.path) {
+			continue
+		}
+		for _, l := range lines {
+			for pc, op := range s.vm.Program {
+				if ol, _ := s.position(op.Pos); ol >= l {
+					pcs[l] = pc
+					s.breakpoints[pc] = true
+					break
+				}
+			}
+		}
+	}
+	return pcs
+}
+
+func samePath(a, b string) bool {
+	if a == b {
+		return true
+	}
+	aa, err1 := filepath.Abs(a)
+	bb, err2 := filepath.Abs(b)
+	return err1 == nil && err2 == nil && aa == bb
+}
+
+// start starts the program when it is launched and configured.
+func (s *session) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.launched || !s.configured || s.running {
+		return
+	}
+	s.running = true
+	go s.run()
+}
+
+func (s *session) run() {
+	defer close(s.done)
+
+	s.out = &outputWriter{conn: s.conn, category: "stdout"}
+	err := s.dbg.Run(s.ctx, s.vm, s.input, s.out)
+	s.out.Flush()
+	if err == wspace.ErrContextDone {
+		return // disconnected
+	}
+
+	code := 0
+	if err != nil {
+		msg := err.Error()
+		if op := s.vm.CurrentOpCode(); op != nil {
+			l, c := s.position(op.Pos)
+			msg = fmt.Sprintf("%s:%v:%v: %v: %v", s.path, l, c, op.Cmd, err)
+		}
+		_ = s.conn.event("output", map[string]any{"category": "stderr", "output": msg + "\n"})
+		code = 1
+	}
+	_ = s.conn.event("exited", map[string]any{"exitCode": code})
+	_ = s.conn.event("terminated", nil)
+}
+
+// Breakpoint implements debug.Frontend.
+func (s *session) Breakpoint(vm *wspace.VM, pc int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.breakpoints[pc]
+}
+
+// Locate implements debug.Frontend.
+func (s *session) Locate(vm *wspace.VM, pc int) (debug.Location, bool) {
+	line, col := s.position(vm.Program[pc].Pos)
+	return debug.Location{
+		Name:   filepath.Base(s.path),
+		Path:   s.path,
+		Line:   line - 1 + s.lineBase,
+		Column: col - 1 + s.colBase,
+	}, true
+}
+
+// Stopped implements debug.Frontend.
+func (s *session) Stopped(reason string) {
+	s.out.Flush()
+	_ = s.conn.event("stopped", map[string]any{
+		"reason":            reason,
+		"threadId":          debug.ThreadID,
+		"allThreadsStopped": true,
+	})
+}
+
+func (s *session) variables(args json.RawMessage) (any, error) {
+	var a struct {
+		Ref int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if err := s.checkLaunched(); err != nil {
+		return nil, err
+	}
+	vm, err := s.dbg.StoppedVM()
+	if err != nil {
+		return nil, err
+	}
+	vars, err := s.dbg.Variables(vm, a.Ref)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"variables": vars}, nil
+}
+
+// outputWriter sends the output of the program as the output events.
+// The output is flushed on a newline.
+type outputWriter struct {
+	conn     *conn
+	category string
+	buf      bytes.Buffer
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	if bytes.IndexByte(p, '\n') >= 0 {
+		w.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush sends the buffered output.
+func (w *outputWriter) Flush() {
+	if w.buf.Len() == 0 {
+		return
+	}
+	_ = w.conn.event("output", map[string]any{"category": w.category, "output": w.buf.String()})
+	w.buf.Reset()
+}
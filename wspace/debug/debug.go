@@ -0,0 +1,308 @@
+// debug package provides a debug session of the whitespace VM
+// for the [Debug Adapter Protocol].
+//
+// [Debug Adapter Protocol]: https://microsoft.github.io/debug-adapter-protocol/
+package debug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+const ThreadID = 1 // the VM has only one thread
+
+// variablesReference of the scopes
+const (
+	RefStack = iota + 1
+	RefHeap
+	RefCallStack
+)
+
+// StepMode is the condition to stop the execution next.
+type StepMode int
+
+const (
+	StepNone StepMode = iota // run until a breakpoint
+	StepIn                   // stop at the next opcode
+	StepOver                 // stop at the next opcode in the same or outer subroutine
+	StepOut                  // stop at the next opcode in the outer subroutine
+)
+
+var ErrNotStopped = errors.New("program is not stopped")
+
+// Location is the position of an opcode in the source.
+type Location struct {
+	Name   string // name of the source
+	Path   string // path of the source
+	Line   int
+	Column int
+}
+
+// Frontend is the side of the session which knows the sources and talks to the client.
+type Frontend interface {
+	// Breakpoint reports whether the execution stops before the opcode at pc.
+	// It is called before each opcode in the order of the execution.
+	Breakpoint(vm *wspace.VM, pc int) bool
+
+	// Locate returns the location of the opcode at pc.
+	Locate(vm *wspace.VM, pc int) (Location, bool)
+
+	// Stopped is called when the execution is stopped for the reason.
+	Stopped(reason string)
+}
+
+// Session runs the VM step by step, stopping at the breakpoints and the steps.
+// While the execution is stopped, the VM can be read by the other goroutines.
+type Session struct {
+	front Frontend
+
+	mu      sync.Mutex
+	vm      *wspace.VM
+	stopped bool
+	entry   bool // stop before the first opcode
+	mode    StepMode
+	depth   int // call depth where the step started
+	resume  chan StepMode
+}
+
+func NewSession(front Frontend) *Session {
+	return &Session{
+		front:  front,
+		resume: make(chan StepMode, 1),
+	}
+}
+
+// Run runs the VM like vm.Run, stopping at the breakpoints and the steps.
+func (s *Session) Run(ctx context.Context, vm *wspace.VM, in wspace.InputReader, out io.Writer) error {
+	s.mu.Lock()
+	s.vm = vm
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.mode = StepNone
+		s.entry = false
+	}()
+
+	for !vm.Terminated {
+		select {
+		case <-ctx.Done():
+			return wspace.ErrContextDone
+		default:
+		}
+		if reason := s.stopReason(vm); reason != "" {
+			if err := s.stop(ctx, vm, reason); err != nil {
+				return err
+			}
+		}
+		if err := vm.Step(in, out); err != nil {
+			if err == wspace.ErrNotLoaded {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// StopOnEntry makes the next Run stop before the first opcode.
+func (s *Session) StopOnEntry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entry = true
+}
+
+// Pause stops the execution before the next opcode.
+func (s *Session) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mode = StepIn
+}
+
+// Continue resumes the stopped execution.
+func (s *Session) Continue(mode StepMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		return ErrNotStopped
+	}
+	select {
+	case s.resume <- mode:
+	default:
+	}
+	return nil
+}
+
+// Stopped reports whether the execution is stopped.
+func (s *Session) Stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// StoppedVM returns the VM if the execution is stopped.
+// The VM is not changed until the execution is resumed.
+func (s *Session) StoppedVM() (*wspace.VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		return nil, ErrNotStopped
+	}
+	return s.vm, nil
+}
+
+// stopReason returns the reason to stop before the current opcode, or "" to continue.
+func (s *Session) stopReason(vm *wspace.VM) string {
+	if vm.PC >= len(vm.Program) {
+		return ""
+	}
+	bp := s.front.Breakpoint(vm, vm.PC)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entry {
+		s.entry = false
+		return "entry"
+	}
+	if bp {
+		return "breakpoint"
+	}
+	switch s.mode {
+	case StepIn:
+		return "step"
+	case StepOver:
+		if len(vm.CallStack) <= s.depth {
+			return "step"
+		}
+	case StepOut:
+		if len(vm.CallStack) < s.depth {
+			return "step"
+		}
+	}
+	return ""
+}
+
+// stop waits for the client to resume the execution.
+func (s *Session) stop(ctx context.Context, vm *wspace.VM, reason string) error {
+	s.mu.Lock()
+	select {
+	case <-s.resume: // drop the stale request
+	default:
+	}
+	s.stopped = true
+	s.mu.Unlock()
+
+	s.front.Stopped(reason)
+
+	var mode StepMode
+	var err error
+	select {
+	case mode = <-s.resume:
+	case <-ctx.Done():
+		err = wspace.ErrContextDone
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = false
+	s.mode = mode
+	s.depth = len(vm.CallStack)
+	return err
+}
+
+// StackTrace returns the body of the stackTrace response.
+func (s *Session) StackTrace() (any, error) {
+	vm, err := s.StoppedVM()
+	if err != nil {
+		return nil, err
+	}
+	// the innermost frame is at the current opcode and the others are at the calls.
+	frames := []map[string]any{s.frame(vm, 0, len(vm.CallStack), vm.PC)}
+	for i := len(vm.CallStack) - 1; i >= 0; i-- {
+		frames = append(frames, s.frame(vm, len(frames), i, vm.CallStack[i]-1))
+	}
+	return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// frame returns the stack frame at the opcode in the subroutine of the depth.
+func (s *Session) frame(vm *wspace.VM, id, depth, pc int) map[string]any {
+	name := "(main)"
+	if depth > 0 {
+		if l, ok := vm.Program[vm.CallStack[depth-1]-1].Param.(string); ok {
+			name = wspace.LabelName(l)
+		}
+	}
+	f := map[string]any{"id": id, "name": name, "line": 0, "column": 0}
+	if pc < 0 || pc >= len(vm.Program) {
+		return f
+	}
+	f["name"] = fmt.Sprintf("%s: %v", name, vm.Program[pc].Cmd)
+	f["instructionPointerReference"] = strconv.Itoa(pc)
+	if loc, ok := s.front.Locate(vm, pc); ok {
+		f["line"] = loc.Line
+		f["column"] = loc.Column
+		f["source"] = map[string]any{"name": loc.Name, "path": loc.Path}
+	}
+	return f
+}
+
+// Scopes returns the body of the scopes response.
+func Scopes() any {
+	return map[string]any{
+		"scopes": []map[string]any{
+			{"name": "Stack", "variablesReference": RefStack, "expensive": false},
+			{"name": "Heap", "variablesReference": RefHeap, "expensive": false},
+			{"name": "Call stack", "variablesReference": RefCallStack, "expensive": false},
+		},
+	}
+}
+
+// Variables returns the variables of the scope in the VM.
+// The VM must not be running.
+func (s *Session) Variables(vm *wspace.VM, ref int) ([]map[string]any, error) {
+	vars := []map[string]any{}
+	switch ref {
+	case RefStack:
+		for i := len(vm.Stack) - 1; i >= 0; i-- {
+			vars = append(vars, variable(strconv.Itoa(len(vm.Stack)-1-i), strconv.Itoa(vm.Stack[i]), "int"))
+		}
+	case RefHeap:
+		addrs := make([]int, 0, len(vm.Heap))
+		for a := range vm.Heap {
+			addrs = append(addrs, a)
+		}
+		sort.Ints(addrs)
+		for _, a := range addrs {
+			vars = append(vars, variable(fmt.Sprintf("[%d]", a), strconv.Itoa(vm.Heap[a]), "int"))
+		}
+	case RefCallStack:
+		for i := len(vm.CallStack) - 1; i >= 0; i-- {
+			vars = append(vars, variable(strconv.Itoa(len(vm.CallStack)-1-i), "return to "+s.position(vm, vm.CallStack[i]), ""))
+		}
+	default:
+		return nil, fmt.Errorf("invalid variablesReference: %v", ref)
+	}
+	return vars, nil
+}
+
+// position returns the line and column of the opcode at pc in the visible notation.
+func (s *Session) position(vm *wspace.VM, pc int) string {
+	if pc >= len(vm.Program) {
+		return "end"
+	}
+	if loc, ok := s.front.Locate(vm, pc); ok {
+		return fmt.Sprintf("%v:%v", loc.Line, loc.Column)
+	}
+	return fmt.Sprintf("opcode %v", pc)
+}
+
+func variable(name, value, typ string) map[string]any {
+	return map[string]any{"name": name, "value": value, "type": typ, "variablesReference": 0}
+}
@@ -0,0 +1,127 @@
+package debug
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+// frontend stops at the PCs and reports the reasons.
+type frontend struct {
+	bps     map[int]bool
+	stopped chan string
+}
+
+func (f *frontend) Breakpoint(vm *wspace.VM, pc int) bool {
+	return f.bps[pc]
+}
+
+func (f *frontend) Locate(vm *wspace.VM, pc int) (Location, bool) {
+	return Location{Name: "test", Path: "test.ws", Line: pc + 1, Column: 1}, true
+}
+
+func (f *frontend) Stopped(reason string) {
+	f.stopped <- reason
+}
+
+func TestSession(t *testing.T) {
+	// push 1; push 2; call A; outnum; end; A: add; return
+	vm := wspace.New()
+	if _, _, err := vm.Load([]byte("   \t\n   \t \n\n \t \n\t\n \t\n\n\n\n   \n\t   \n\t\n")); err != nil {
+		t.Fatal(err)
+	}
+	front := &frontend{bps: map[int]bool{6: true}, stopped: make(chan string)}
+	s := NewSession(front)
+	s.StopOnEntry()
+
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(context.Background(), vm, bufio.NewReader(strings.NewReader("")), &out)
+	}()
+
+	tests := []struct {
+		reason string
+		frames []string
+		next   StepMode
+	}{
+		{"entry", []string{"(main): Push"}, StepOver},
+		{"step", []string{"(main): Push"}, StepNone},
+		{"breakpoint", []string{".: Add", "(main): Call"}, StepOut},
+		{"step", []string{"(main): WriteNum"}, StepNone},
+	}
+	for _, test := range tests {
+		select {
+		case r := <-front.stopped:
+			if r != test.reason {
+				t.Fatalf("reason=%q, wants %q", r, test.reason)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("not stopped: %v", test.reason)
+		}
+		body, err := s.StackTrace()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := body.(map[string]any)["stackFrames"].([]map[string]any)
+		if len(frames) != len(test.frames) {
+			t.Fatalf("frames: %v, wants %v", frames, test.frames)
+		}
+		for i, f := range frames {
+			if f["name"] != test.frames[i] {
+				t.Fatalf("frame %v: %v, wants %v", i, f, test.frames[i])
+			}
+		}
+		if err := s.Continue(test.next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "3" {
+		t.Fatalf("output=%q", out.String())
+	}
+	if err := s.Continue(StepNone); err != ErrNotStopped {
+		t.Fatalf("Continue after Run: %v", err)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	vm := wspace.New()
+	vm.Program = []wspace.OpCode{{Cmd: wspace.Ret}, {Cmd: wspace.End}}
+	vm.Stack = []int{1, 2}
+	vm.Heap = map[int]int{3: 30, 1: 10}
+	vm.CallStack = []int{1, 2}
+	s := NewSession(&frontend{})
+
+	tests := []struct {
+		ref    int
+		values []string
+	}{
+		{RefStack, []string{"0=2", "1=1"}},
+		{RefHeap, []string{"[1]=10", "[3]=30"}},
+		{RefCallStack, []string{"0=return to end", "1=return to 2:1"}},
+	}
+	for _, test := range tests {
+		vars, err := s.Variables(vm, test.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vs []string
+		for _, v := range vars {
+			vs = append(vs, v["name"].(string)+"="+v["value"].(string))
+		}
+		if strings.Join(vs, " ") != strings.Join(test.values, " ") {
+			t.Fatalf("ref %v: %v, wants %v", test.ref, vs, test.values)
+		}
+	}
+	if _, err := s.Variables(vm, 0); err == nil {
+		t.Fatalf("no error on the invalid reference")
+	}
+}
@@ -0,0 +1,84 @@
+package wspace
+
+// Encode returns the whitespace code of the program.
+// Numbers are encoded without redundant leading bits.
+func Encode(prog []OpCode) []byte {
+	code := make([]byte, 0, len(prog)*4)
+	for _, op := range prog {
+		code = appendOpCode(code, op)
+	}
+	return code
+}
+
+// Code returns the whitespace code of the command without the parameter.
+func (c Command) Code() string {
+	return commandCodes[c]
+}
+
+var commandCodes = map[Command]string{
+	Push:      "  ",
+	Dup:       " \n ",
+	Copy:      " \t ",
+	Swap:      " \n\t",
+	Discard:   " \n\n",
+	Slide:     " \t\n",
+	Add:       "\t   ",
+	Sub:       "\t  \t",
+	Mul:       "\t  \n",
+	Div:       "\t \t ",
+	Mod:       "\t \t\t",
+	Store:     "\t\t ",
+	Retrieve:  "\t\t\t",
+	Mark:      "\n  ",
+	Call:      "\n \t",
+	Jump:      "\n \n",
+	JZero:     "\n\t ",
+	JNeg:      "\n\t\t",
+	Ret:       "\n\t\n",
+	End:       "\n\n\n",
+	WriteChar: "\t\n  ",
+	WriteNum:  "\t\n \t",
+	ReadChar:  "\t\n\t ",
+	ReadNum:   "\t\n\t\t",
+
+	DebugStack: "\n\n  ",
+	DebugHeap:  "\n\n \t",
+	Trace:      "\n\n\t ",
+}
+
+func appendOpCode(code []byte, op OpCode) []byte {
+	code = append(code, commandCodes[op.Cmd]...)
+	switch p := op.Param.(type) {
+	case int:
+		code = appendNum(code, p)
+	case string:
+		code = appendLabel(code, p)
+	}
+	return code
+}
+
+func appendNum(code []byte, n int) []byte {
+	if n < 0 {
+		code = append(code, '\t')
+		n = -n
+	} else {
+		code = append(code, ' ')
+	}
+	bits := 0
+	for v := n; v > 0; v >>= 1 {
+		bits++
+	}
+	for i := bits - 1; i >= 0; i-- {
+		if n&(1<<i) != 0 {
+			code = append(code, '\t')
+		} else {
+			code = append(code, ' ')
+		}
+	}
+	return append(code, '\n')
+}
+
+func appendLabel(code []byte, l string) []byte {
+	code = append(code, l...)
+	return append(code, '\n')
+}
@@ -1,5 +1,7 @@
 package wspace
 
+import "fmt"
+
 type Error string
 
 const (
@@ -14,6 +16,13 @@ const (
 	ErrUndefinedLabel = Error("undefined label")
 	ErrEmptyCallStack = Error("callstack is empty")
 	ErrContextDone    = Error("context done")
+	ErrEOF            = Error("end of input")
+	ErrInvalidNumber  = Error("invalid number")
+
+	ErrProgramMismatch = Error("program mismatch")
+	ErrNoHiddenProgram = Error("no hidden program")
+	ErrCoverTooShort   = Error("cover text is too short")
+	ErrInvalidCover    = Error("cover text contains no-break spaces or em spaces")
 
 	ErrUnknownOpCode = Error("unknown opcode")
 )
@@ -21,3 +30,17 @@ const (
 func (e Error) Error() string {
 	return string(e)
 }
+
+// InputError is the error of ReadChar and ReadNum.
+type InputError struct {
+	Addr int // heap address to be stored
+	Err  error
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("heap[%d]: %v", e.Addr, e.Err)
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
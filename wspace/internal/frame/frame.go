@@ -0,0 +1,51 @@
+// frame package reads and writes the messages framed by the Content-Length header,
+// which is the base protocol of the Debug Adapter Protocol and the Language Server Protocol.
+package frame
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// Reader reads the framed messages.
+type Reader struct {
+	r *textproto.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: textproto.NewReader(bufio.NewReader(r))}
+}
+
+// Read returns the body of the next message.
+// It returns io.EOF when the stream ends between the messages.
+func (r *Reader) Read() ([]byte, error) {
+	hdr, err := r.r.ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(hdr.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length: %q", hdr.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r.r.R, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Write writes the body as a message.
+func Write(w io.Writer, body []byte) error {
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
@@ -0,0 +1,46 @@
+package frame
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	var b bytes.Buffer
+	for _, m := range []string{`{"seq":1}`, ``, `{"text":"あ"}`} {
+		if err := Write(&b, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewReader(&b)
+	for _, m := range []string{`{"seq":1}`, ``, `{"text":"あ"}`} {
+		body, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != m {
+			t.Fatalf("body=%q, wants %q", body, m)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Fatalf("err=%v, wants EOF", err)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	tests := map[string]string{
+		"nolength": "Content-Type: application/json\r\n\r\n{}",
+		"invalid":  "Content-Length: x\r\n\r\n{}",
+		"negative": "Content-Length: -1\r\n\r\n{}",
+		"short":    "Content-Length: 10\r\n\r\n{}",
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewReader(strings.NewReader(in)).Read(); err == nil || err == io.EOF {
+				t.Fatalf("err=%v", err)
+			}
+		})
+	}
+}
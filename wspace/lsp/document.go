@@ -0,0 +1,329 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/makiuchi-d/whitenote/wspace"
+)
+
+// role of a whitespace character in the instruction
+type role int
+
+const (
+	roleIMP        role = iota // instruction modification parameter
+	roleCommand                // command
+	roleNumber                 // number parameter
+	roleLabel                  // label parameter
+	roleTerminator             // LF terminating the parameter
+)
+
+// tokenTypes is the legend of the semantic tokens in the order of the roles.
+var tokenTypes = []string{"namespace", "keyword", "number", "function", "operator"}
+
+// instruction is a loaded opcode and the whitespace characters of it.
+type instruction struct {
+	wspace.OpCode
+	chars []int  // byte offsets of the whitespace characters
+	roles []role // role of each character
+}
+
+func (in *instruction) start() int { return in.chars[0] }
+func (in *instruction) end() int   { return in.chars[len(in.chars)-1] + 1 }
+
+func (in *instruction) label() (string, bool) {
+	l, ok := in.Param.(string)
+	return l, ok
+}
+
+// document is an analyzed Whitespace source.
+type document struct {
+	src        []byte
+	lineStarts []int
+	insts      []instruction
+	labels     map[string]int // label -> index of the Mark instruction
+	err        error          // loading error
+	errPos     int
+}
+
+func newDocument(src []byte) *document {
+	d := &document{
+		src:        src,
+		lineStarts: []int{0},
+		labels:     make(map[string]int),
+	}
+	for i, c := range src {
+		if c == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+
+	vm := wspace.New()
+	_, pos, err := vm.Load(src)
+	if err != nil {
+		d.err, d.errPos = err, pos
+	}
+	for _, op := range vm.Program {
+		d.insts = append(d.insts, d.instruction(op))
+	}
+	for l, i := range vm.Labels {
+		d.labels[l] = i
+	}
+	return d
+}
+
+// instruction splits the characters of the opcode by the roles.
+func (d *document) instruction(op wspace.OpCode) instruction {
+	in := instruction{OpCode: op}
+	n := len(op.Cmd.Code())
+	imp := 1
+	if op.Cmd.Code()[0] == '\t' {
+		imp = 2
+	}
+	param := roleNumber
+	if _, ok := op.Param.(string); ok {
+		param = roleLabel
+	}
+
+	for p := op.Pos; p < len(d.src); p++ {
+		c := d.src[p]
+		if c != ' ' && c != '\t' && c != '\n' {
+			continue
+		}
+		i := len(in.chars)
+		switch {
+		case i < imp:
+			in.roles = append(in.roles, roleIMP)
+		case i < n:
+			in.roles = append(in.roles, roleCommand)
+		case c == '\n':
+			in.roles = append(in.roles, roleTerminator)
+		default:
+			in.roles = append(in.roles, param)
+		}
+		in.chars = append(in.chars, p)
+		if (op.Param == nil && i+1 >= n) || (i >= n && c == '\n') {
+			break
+		}
+	}
+	return in
+}
+
+// position is a zero-based line and UTF-16 character offset.
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+// position converts the byte offset to the position.
+func (d *document) position(off int) position {
+	l := sort.Search(len(d.lineStarts), func(i int) bool { return d.lineStarts[i] > off }) - 1
+	return position{Line: l, Character: utf16Len(d.src[d.lineStarts[l]:off])}
+}
+
+// offset converts the position to the byte offset.
+func (d *document) offset(pos position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lineStarts) {
+		return len(d.src)
+	}
+	off := d.lineStarts[pos.Line]
+	for n := 0; n < pos.Character && off < len(d.src) && d.src[off] != '\n'; {
+		r, size := utf8.DecodeRune(d.src[off:])
+		n += len(utf16.Encode([]rune{r}))
+		off += size
+	}
+	return off
+}
+
+func utf16Len(b []byte) int {
+	n := 0
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		n += len(utf16.Encode([]rune{r}))
+		b = b[size:]
+	}
+	return n
+}
+
+func (d *document) rangeOf(start, end int) lspRange {
+	return lspRange{Start: d.position(start), End: d.position(end)}
+}
+
+// instructionAt returns the instruction containing the offset.
+func (d *document) instructionAt(off int) *instruction {
+	for i := range d.insts {
+		in := &d.insts[i]
+		if in.start() <= off && off < in.end() {
+			return in
+		}
+	}
+	return nil
+}
+
+const (
+	severityError = 1
+	severityHint  = 4
+)
+
+type diagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+// diagnostics returns the loading error and the results of the static checks.
+func (d *document) diagnostics() []diagnostic {
+	diags := []diagnostic{}
+	add := func(start, end, severity int, msg string) {
+		diags = append(diags, diagnostic{d.rangeOf(start, end), severity, "wspace", msg})
+	}
+
+	if d.err != nil {
+		end := d.errPos
+		if end < len(d.src) {
+			end++
+		}
+		add(d.errPos, end, severityError, d.err.Error())
+	}
+
+	used := make(map[string]bool)
+	for i := range d.insts {
+		in := &d.insts[i]
+		l, ok := in.label()
+		if !ok || in.Cmd == wspace.Mark {
+			continue
+		}
+		used[l] = true
+		if _, ok := d.labels[l]; !ok {
+			add(in.start(), in.end(), severityError, fmt.Sprintf("%v: %q", wspace.ErrUndefinedLabel, wspace.LabelName(l)))
+		}
+	}
+	for i := range d.insts {
+		in := &d.insts[i]
+		if l, ok := in.label(); ok && in.Cmd == wspace.Mark && !used[l] {
+			add(in.start(), in.end(), severityHint, fmt.Sprintf("unused label: %q", wspace.LabelName(l)))
+		}
+	}
+	return diags
+}
+
+// hover returns the description of the instruction in markdown.
+func (d *document) hover(in *instruction) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%v**", in.Cmd)
+	switch p := in.Param.(type) {
+	case int:
+		fmt.Fprintf(&b, " %d", p)
+	case string:
+		fmt.Fprintf(&b, " %s", wspace.LabelName(p))
+	}
+	fmt.Fprintf(&b, "\n\nstack: `%s`", in.Cmd.StackEffect())
+	return b.String()
+}
+
+// definition returns the Mark instruction of the label used by the instruction.
+func (d *document) definition(in *instruction) *instruction {
+	l, ok := in.label()
+	if !ok {
+		return nil
+	}
+	i, ok := d.labels[l]
+	if !ok {
+		return nil
+	}
+	return &d.insts[i]
+}
+
+// references returns the instructions using the label of the instruction.
+func (d *document) references(in *instruction, decl bool) []*instruction {
+	l, ok := in.label()
+	if !ok {
+		return nil
+	}
+	var refs []*instruction
+	for i := range d.insts {
+		r := &d.insts[i]
+		if rl, ok := r.label(); ok && rl == l && (decl || r.Cmd != wspace.Mark) {
+			refs = append(refs, r)
+		}
+	}
+	return refs
+}
+
+type documentSymbol struct {
+	Name           string   `json:"name"`
+	Detail         string   `json:"detail,omitempty"`
+	Kind           int      `json:"kind"`
+	Range          lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+}
+
+const symbolKindFunction = 12
+
+// symbols returns the labels.
+func (d *document) symbols() []documentSymbol {
+	syms := []documentSymbol{}
+	for i := range d.insts {
+		in := &d.insts[i]
+		if l, ok := in.label(); ok && in.Cmd == wspace.Mark {
+			r := d.rangeOf(in.start(), in.end())
+			syms = append(syms, documentSymbol{
+				Name:           wspace.LabelName(l),
+				Detail:         "label",
+				Kind:           symbolKindFunction,
+				Range:          r,
+				SelectionRange: r,
+			})
+		}
+	}
+	return syms
+}
+
+// semanticTokens returns the encoded semantic tokens colouring the whitespaces by the roles.
+// The adjacent characters of the same role on a line are merged into a token.
+func (d *document) semanticTokens() []int {
+	type token struct {
+		pos    position
+		length int
+		role   role
+	}
+	var toks []token
+	for i := range d.insts {
+		in := &d.insts[i]
+		for j, c := range in.chars {
+			p := d.position(c)
+			if n := len(toks); n > 0 {
+				t := &toks[n-1]
+				if t.role == in.roles[j] && t.pos.Line == p.Line && t.pos.Character+t.length == p.Character {
+					t.length++
+					continue
+				}
+			}
+			toks = append(toks, token{p, 1, in.roles[j]})
+		}
+	}
+
+	data := make([]int, 0, len(toks)*5)
+	var prev position
+	for _, t := range toks {
+		dl, dc := t.pos.Line-prev.Line, t.pos.Character
+		if dl == 0 {
+			dc -= prev.Character
+		}
+		data = append(data, dl, dc, t.length, int(t.role), 0)
+		prev = t.pos
+	}
+	return data
+}
@@ -0,0 +1,271 @@
+// lsp package provides a [Language Server Protocol] server for whitespace.
+//
+// [Language Server Protocol]: https://microsoft.github.io/language-server-protocol/
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/makiuchi-d/whitenote/wspace/internal/frame"
+)
+
+// message is a JSON-RPC 2.0 message.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+type textDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position position `json:"position"`
+}
+
+type location struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+// server is a language server session.
+type server struct {
+	r    *frame.Reader
+	w    io.Writer
+	docs map[string]*document // uri -> document
+}
+
+// Serve runs a language server on the connection until the exit notification.
+// The documents are synchronized in full.
+func Serve(rw io.ReadWriter) error {
+	s := &server{
+		r:    frame.NewReader(rw),
+		w:    rw,
+		docs: make(map[string]*document),
+	}
+	for {
+		body, err := s.r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			// the id is unknown
+			null := json.RawMessage("null")
+			rep := &message{JSONRPC: "2.0", ID: &null, Error: &rpcError{codeParseError, "parse error: " + err.Error()}}
+			if err := s.write(rep); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(&msg)
+		if msg.ID == nil {
+			continue // notification
+		}
+		rep := &message{JSONRPC: "2.0", ID: msg.ID, Result: result, Error: rerr}
+		if rerr == nil && result == nil {
+			rep.Result = json.RawMessage("null")
+		}
+		if err := s.write(rep); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) write(msg *message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return frame.Write(s.w, body)
+}
+
+func (s *server) notify(method string, params any) error {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.write(&message{JSONRPC: "2.0", Method: method, Params: p})
+}
+
+func (s *server) handle(msg *message) (any, *rpcError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       1, // full
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"documentSymbolProvider": true,
+				"semanticTokensProvider": map[string]any{
+					"legend": map[string]any{
+						"tokenTypes":     tokenTypes,
+						"tokenModifiers": []string{},
+					},
+					"full": true,
+				},
+			},
+			"serverInfo": map[string]any{"name": "wspace"},
+		}, nil
+	case "initialized", "shutdown":
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.update(p.TextDocument.URI, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p textDocumentPosition
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.docs, p.TextDocument.URI)
+		_ = s.notify("textDocument/publishDiagnostics", map[string]any{
+			"uri":         p.TextDocument.URI,
+			"diagnostics": []diagnostic{},
+		})
+		return nil, nil
+
+	case "textDocument/hover":
+		d, in, err := s.instructionAt(msg.Params)
+		if err != nil || in == nil {
+			return nil, err
+		}
+		return map[string]any{
+			"contents": map[string]any{"kind": "markdown", "value": d.hover(in)},
+			"range":    d.rangeOf(in.start(), in.end()),
+		}, nil
+	case "textDocument/definition":
+		d, in, err := s.instructionAt(msg.Params)
+		if err != nil || in == nil {
+			return nil, err
+		}
+		def := d.definition(in)
+		if def == nil {
+			return nil, nil
+		}
+		return location{s.uriOf(msg.Params), d.rangeOf(def.start(), def.end())}, nil
+	case "textDocument/references":
+		d, in, err := s.instructionAt(msg.Params)
+		if err != nil || in == nil {
+			return nil, err
+		}
+		var p struct {
+			Context struct {
+				IncludeDeclaration bool `json:"includeDeclaration"`
+			} `json:"context"`
+		}
+		_ = json.Unmarshal(msg.Params, &p)
+		locs := []location{}
+		for _, r := range d.references(in, p.Context.IncludeDeclaration) {
+			locs = append(locs, location{s.uriOf(msg.Params), d.rangeOf(r.start(), r.end())})
+		}
+		return locs, nil
+	case "textDocument/documentSymbol":
+		d, err := s.document(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return d.symbols(), nil
+	case "textDocument/semanticTokens/full":
+		d, err := s.document(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"data": d.semanticTokens()}, nil
+	}
+
+	if msg.ID == nil {
+		return nil, nil
+	}
+	return nil, &rpcError{codeMethodNotFound, "method not found: " + msg.Method}
+}
+
+func invalidParams(err error) *rpcError {
+	return &rpcError{codeInvalidParams, err.Error()}
+}
+
+// update analyzes the document and publishes the diagnostics.
+func (s *server) update(uri, text string) {
+	d := newDocument([]byte(text))
+	s.docs[uri] = d
+	_ = s.notify("textDocument/publishDiagnostics", map[string]any{
+		"uri":         uri,
+		"diagnostics": d.diagnostics(),
+	})
+}
+
+func (s *server) uriOf(params json.RawMessage) string {
+	var p textDocumentPosition
+	_ = json.Unmarshal(params, &p)
+	return p.TextDocument.URI
+}
+
+func (s *server) document(params json.RawMessage) (*document, *rpcError) {
+	var p textDocumentPosition
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, invalidParams(err)
+	}
+	d, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, &rpcError{codeInvalidParams, "unknown document: " + p.TextDocument.URI}
+	}
+	return d, nil
+}
+
+func (s *server) instructionAt(params json.RawMessage) (*document, *instruction, *rpcError) {
+	d, err := s.document(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	var p textDocumentPosition
+	_ = json.Unmarshal(params, &p)
+	return d, d.instructionAt(d.offset(p.Position)), nil
+}
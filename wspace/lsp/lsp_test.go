@@ -0,0 +1,184 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// call A; end; A: push -1; return; jump B
+const testCode = "callA\n \t \n" +
+	"end\n\n\n" +
+	"markA\n   \n" +
+	"push-1  \t\t\n" +
+	"return\n\t\n" +
+	"jumpB\n \n\t\n"
+
+func TestDiagnostics(t *testing.T) {
+	tests := map[string]struct {
+		code string
+		msgs []string
+	}{
+		"ok":         {"   \t\n\n\n\n", nil},
+		"invalid":    {"   \t\n\t\n\n", []string{"invalid sequence"}},
+		"incomplete": {"   \t", []string{"incomplete sequence"}},
+		"labels":     {testCode, []string{`undefined label: "_"`}},
+		"unused":     {"\n   \n\n  \t\n\n \n \n", []string{`unused label: "_"`}},
+	}
+	for k, test := range tests {
+		var msgs []string
+		for _, d := range newDocument([]byte(test.code)).diagnostics() {
+			msgs = append(msgs, d.Message)
+		}
+		if !reflect.DeepEqual(msgs, test.msgs) {
+			t.Fatalf("%v: %q, wants %q", k, msgs, test.msgs)
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	d := newDocument([]byte(testCode))
+
+	// "call A" from line 0 to 1
+	in := d.instructionAt(d.offset(position{1, 1}))
+	if in == nil {
+		t.Fatalf("no instruction")
+	}
+	def := d.definition(in)
+	if def == nil {
+		t.Fatalf("no definition")
+	}
+	if r := d.rangeOf(def.start(), def.end()); r.Start != (position{5, 5}) || r.End != (position{7, 0}) {
+		t.Fatalf("definition: %v", r)
+	}
+
+	refs := d.references(def, true)
+	if len(refs) != 2 || refs[0] != in || refs[1] != def {
+		t.Fatalf("references: %v", refs)
+	}
+	if refs := d.references(def, false); len(refs) != 1 {
+		t.Fatalf("references without the declaration: %v", refs)
+	}
+
+	syms := d.symbols()
+	if len(syms) != 1 || syms[0].Name != "." {
+		t.Fatalf("symbols: %v", syms)
+	}
+
+	in = d.instructionAt(d.offset(position{7, 7}))
+	if h := d.hover(in); h != "**Push** -1\n\nstack: `-- n`" {
+		t.Fatalf("hover: %q", h)
+	}
+}
+
+func TestSemanticTokens(t *testing.T) {
+	// push 1; end
+	d := newDocument([]byte("  x \t\n\n\n\n"))
+	expect := []int{
+		0, 0, 1, int(roleIMP), 0,
+		0, 1, 1, int(roleCommand), 0,
+		0, 2, 2, int(roleNumber), 0,
+		0, 2, 1, int(roleTerminator), 0,
+		1, 0, 1, int(roleIMP), 0,
+		1, 0, 1, int(roleCommand), 0,
+		1, 0, 1, int(roleCommand), 0,
+	}
+	if data := d.semanticTokens(); !reflect.DeepEqual(data, expect) {
+		t.Fatalf("%v, wants %v", data, expect)
+	}
+}
+
+func TestServe(t *testing.T) {
+	sc, cc := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(sc)
+		sc.Close()
+	}()
+	defer cc.Close()
+
+	r := textproto.NewReader(bufio.NewReader(cc))
+	recv := func() map[string]any {
+		hdr, err := r.ReadMIMEHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := strconv.Atoi(hdr.Get("Content-Length"))
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r.R, body); err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]any
+		_ = json.Unmarshal(body, &m)
+		return m
+	}
+	send := func(id int, method string, params any) {
+		m := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+		if id > 0 {
+			m["id"] = id
+		}
+		body, _ := json.Marshal(m)
+		go cc.Write(append([]byte("Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"), body...))
+	}
+
+	send(1, "initialize", map[string]any{})
+	if m := recv(); m["id"] != float64(1) || m["result"] == nil {
+		t.Fatalf("initialize: %v", m)
+	}
+	send(0, "initialized", map[string]any{})
+
+	uri := "file:///test.ws"
+	send(0, "textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "whitespace", "version": 1, "text": testCode},
+	})
+	m := recv()
+	if m["method"] != "textDocument/publishDiagnostics" {
+		t.Fatalf("didOpen: %v", m)
+	}
+	if diags := m["params"].(map[string]any)["diagnostics"].([]any); len(diags) != 1 {
+		t.Fatalf("diagnostics: %v", diags)
+	}
+
+	send(2, "textDocument/definition", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": 1, "character": 1},
+	})
+	m = recv()
+	res, _ := m["result"].(map[string]any)
+	if m["id"] != float64(2) || res["uri"] != uri {
+		t.Fatalf("definition: %v", m)
+	}
+	start := res["range"].(map[string]any)["start"].(map[string]any)
+	if start["line"] != float64(5) || start["character"] != float64(5) {
+		t.Fatalf("definition: %v", res)
+	}
+
+	send(3, "unknown/method", nil)
+	if m := recv(); m["error"] == nil || !strings.Contains(m["error"].(map[string]any)["message"].(string), "unknown/method") {
+		t.Fatalf("unknown: %v", m)
+	}
+
+	go cc.Write([]byte("Content-Length: 8\r\n\r\n{invalid"))
+	m = recv()
+	if id, ok := m["id"]; !ok || id != nil {
+		t.Fatalf("parse error: %v", m)
+	}
+	if e, _ := m["error"].(map[string]any); e == nil || e["code"] != float64(codeParseError) {
+		t.Fatalf("parse error: %v", m)
+	}
+
+	send(4, "shutdown", nil)
+	if m := recv(); m["id"] != float64(4) {
+		t.Fatalf("shutdown: %v", m)
+	}
+	send(0, "exit", nil)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,109 @@
+package wspace
+
+import "sort"
+
+// Minify returns the program that behaves identically to prog with its code size minimized.
+//
+// Unreachable opcodes and unused marks are removed, and the labels are renamed to
+// the shortest unique names: the most used label gets the shortest one.
+// Use Encode to get the minified code.
+func Minify(prog []OpCode) []OpCode {
+	reach := reachable(prog)
+
+	used := make(map[string]bool)
+	for i, op := range prog {
+		if reach[i] && isJump(op.Cmd) {
+			used[op.Param.(string)] = true
+		}
+	}
+
+	out := make([]OpCode, 0, len(prog))
+	count := make(map[string]int)
+	order := make([]string, 0)
+	for i, op := range prog {
+		if !reach[i] {
+			continue
+		}
+		if op.Cmd == Mark && !used[op.Param.(string)] {
+			continue
+		}
+		if l, ok := op.Param.(string); ok {
+			if _, exists := count[l]; !exists {
+				order = append(order, l)
+			}
+			count[l]++
+		}
+		out = append(out, op)
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return count[order[i]] > count[order[j]]
+	})
+	names := make(map[string]string, len(order))
+	for i, l := range order {
+		names[l] = shortLabel(i)
+	}
+	for i, op := range out {
+		if l, ok := op.Param.(string); ok {
+			out[i].Param = names[l]
+		}
+	}
+
+	return out
+}
+
+// reachable reports whether each opcode can be executed from the beginning of the program.
+func reachable(prog []OpCode) []bool {
+	labels := make(map[string]int)
+	for i, op := range prog {
+		if op.Cmd == Mark {
+			labels[op.Param.(string)] = i
+		}
+	}
+
+	reach := make([]bool, len(prog))
+	todo := []int{0}
+	for len(todo) > 0 {
+		pc := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if pc >= len(prog) || reach[pc] {
+			continue
+		}
+		reach[pc] = true
+
+		op := prog[pc]
+		if isJump(op.Cmd) {
+			if p, ok := labels[op.Param.(string)]; ok {
+				todo = append(todo, p)
+			}
+		}
+		switch op.Cmd {
+		case Jump, Ret, End:
+		default:
+			todo = append(todo, pc+1) // Call returns to the next opcode.
+		}
+	}
+	return reach
+}
+
+func isJump(cmd Command) bool {
+	return cmd == Call || cmd == Jump || cmd == JZero || cmd == JNeg
+}
+
+// shortLabel returns the n-th shortest label: "", " ", "\t", "  ", " \t", ...
+func shortLabel(n int) string {
+	l := 0
+	for n >= 1<<l {
+		n -= 1 << l
+		l++
+	}
+	b := make([]byte, l)
+	for i := range b {
+		if n&(1<<(l-i-1)) != 0 {
+			b[i] = '\t'
+		} else {
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,96 @@
+package wspace
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := map[string]struct {
+		prog   []OpCode
+		expect string
+	}{
+		"Push0":   {[]OpCode{{Cmd: Push, Param: 0}}, "   \n"},
+		"Push10":  {[]OpCode{{Cmd: Push, Param: 10}}, "   \t \t \n"},
+		"Push-4":  {[]OpCode{{Cmd: Push, Param: -4}}, "  \t\t  \n"},
+		"Copy1":   {[]OpCode{{Cmd: Copy, Param: 1}}, " \t  \t\n"},
+		"Mark":    {[]OpCode{{Cmd: Mark, Param: "\t "}}, "\n  \t \n"},
+		"Call":    {[]OpCode{{Cmd: Call, Param: ""}}, "\n \t\n"},
+		"Add,End": {[]OpCode{{Cmd: Add}, {Cmd: End}}, "\t   \n\n\n"},
+	}
+	for k, test := range tests {
+		code := Encode(test.prog)
+		if string(code) != test.expect {
+			t.Fatalf("%v: %q, wants %q", k, code, test.expect)
+		}
+	}
+}
+
+func TestShortLabel(t *testing.T) {
+	expects := []string{"", " ", "\t", "  ", " \t", "\t ", "\t\t", "   "}
+	for i, exp := range expects {
+		if l := shortLabel(i); l != exp {
+			t.Fatalf("shortLabel(%v) = %q, wants %q", i, l, exp)
+		}
+	}
+}
+
+func TestMinify(t *testing.T) {
+	prog := []OpCode{
+		{Cmd: Push, Param: 3},
+		{Cmd: Mark, Param: "\t\t\t\t"}, // loop
+		{Cmd: Dup},
+		{Cmd: JZero, Param: "  \t\t"},
+		{Cmd: Dup},
+		{Cmd: WriteNum},
+		{Cmd: Push, Param: 1},
+		{Cmd: Sub},
+		{Cmd: Jump, Param: "\t\t\t\t"},
+		{Cmd: Push, Param: 99}, // dead
+		{Cmd: WriteNum},        // dead
+		{Cmd: Mark, Param: "  \t\t"},
+		{Cmd: Mark, Param: "\t \t \t"}, // unused
+		{Cmd: End},
+		{Cmd: Jump, Param: "  \t\t"}, // dead
+	}
+	expect := []OpCode{
+		{Cmd: Push, Param: 3},
+		{Cmd: Mark, Param: ""},
+		{Cmd: Dup},
+		{Cmd: JZero, Param: " "},
+		{Cmd: Dup},
+		{Cmd: WriteNum},
+		{Cmd: Push, Param: 1},
+		{Cmd: Sub},
+		{Cmd: Jump, Param: ""},
+		{Cmd: Mark, Param: " "},
+		{Cmd: End},
+	}
+
+	minified := Minify(prog)
+	if !reflect.DeepEqual(minified, expect) {
+		t.Fatalf("Minify:\n%v\nwants\n%v", minified, expect)
+	}
+
+	run := func(code []byte) string {
+		vm := New()
+		if _, _, err := vm.Load(code); err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		out := new(bytes.Buffer)
+		if err := vm.Run(context.Background(), nil, out); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return out.String()
+	}
+
+	orig, code := Encode(prog), Encode(minified)
+	if len(code) >= len(orig) {
+		t.Fatalf("size: %v -> %v", len(orig), len(code))
+	}
+	if o, m := run(orig), run(code); o != m || m != "321" {
+		t.Fatalf("output: %q -> %q, wants %q", o, m, "321")
+	}
+}
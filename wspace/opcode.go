@@ -1,6 +1,9 @@
 package wspace
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // OpCode is an operation code contains the command and its parameter, and the position of the definition on the loaded code segment.
 type OpCode struct {
@@ -56,8 +59,50 @@ const (
 	WriteNum  // [Tab][LF][Space][Tab] - : Output the number at the top of the stack
 	ReadChar  // [Tab][LF][Tab][Space] - : Read a character and place it in the location given by the top of the stack
 	ReadNum   // [Tab][LF][Tab][Tab] - : Read a number and place it in the location given by the top of the stack
+
+	// Extensions
+
+	DebugStack // [LF][LF][Space][Space] - : Print the stack for debugging
+	DebugHeap  // [LF][LF][Space][Tab] - : Print the heap for debugging
+	Trace      // [LF][LF][Tab][Space] - : Toggle tracing of the executed opcodes
 )
 
+// StackEffect returns the effect on the stack of the command in the form "before -- after".
+func (c Command) StackEffect() string {
+	return stackEffects[c]
+}
+
+var stackEffects = map[Command]string{
+	Push:      "-- n",
+	Dup:       "a -- a a",
+	Copy:      "x ...n -- x ...n x",
+	Swap:      "a b -- b a",
+	Discard:   "a --",
+	Slide:     "...n a -- a",
+	Add:       "a b -- a+b",
+	Sub:       "a b -- a-b",
+	Mul:       "a b -- a*b",
+	Div:       "a b -- a/b",
+	Mod:       "a b -- a%b",
+	Store:     "addr v --",
+	Retrieve:  "addr -- v",
+	Mark:      "--",
+	Call:      "--",
+	Jump:      "--",
+	JZero:     "a --",
+	JNeg:      "a --",
+	Ret:       "--",
+	End:       "--",
+	WriteChar: "c --",
+	WriteNum:  "n --",
+	ReadChar:  "addr --",
+	ReadNum:   "addr --",
+
+	DebugStack: "--",
+	DebugHeap:  "--",
+	Trace:      "--",
+}
+
 func (op OpCode) String() string {
 	param := ""
 	if op.Param != nil {
@@ -65,3 +110,19 @@ func (op OpCode) String() string {
 	}
 	return fmt.Sprintf("(%v:%v) %s%s", op.Seg, op.Pos, op.Cmd, param)
 }
+
+// LabelName returns the label in the visible notation.
+func LabelName(l string) string {
+	if l == "" {
+		return "(empty)"
+	}
+	return Visualize(l)
+}
+
+// Visualize replaces the whitespaces with the visible characters:
+// '.' for space, '_' for tab and ',' for newline.
+func Visualize(code string) string {
+	return visibleReplacer.Replace(code)
+}
+
+var visibleReplacer = strings.NewReplacer(" ", ".", "\t", "_", "\n", ",")
@@ -0,0 +1,172 @@
+package wspace
+
+import "bytes"
+
+// The text made by Embed is itself the program: its spaces, tabs and newlines are
+// the code and nothing else. The spaces and tabs inside the cover lines are
+// replaced with the no-break space and the em space which are not the code,
+// and the program is put at the end of the lines, split at its newlines which
+// become the line breaks of the cover text.
+// When the cover text has more lines than the program needs, the program is
+// padded with the Push 0 opcodes after its end.
+const (
+	stegoSpace = "\u00a0" // no-break space replacing the spaces of the cover text
+	stegoTab   = "\u2003" // em space replacing the tabs of the cover text
+	stegoPad   = "   \n"  // Push 0 padding the program
+)
+
+// Embed hides the whitespace program in the cover text.
+//
+// The result runs the program on any whitespace interpreter. The spaces and tabs of
+// the cover text are replaced with the no-break spaces and the em spaces, and the
+// cover text must not contain them already so that Extract can restore it.
+// The cover text needs at least as many lines as the newlines of the program.
+func Embed(code, cover []byte) ([]byte, error) {
+	vm := New()
+	if _, _, err := vm.Load(code); err != nil {
+		return nil, err
+	}
+	if bytes.Contains(cover, []byte(stegoSpace)) || bytes.Contains(cover, []byte(stegoTab)) {
+		return nil, ErrInvalidCover
+	}
+	prog := whitespaces(code)
+
+	lines := bytes.Split(cover, []byte{'\n'})
+	breaks := len(lines) - 1
+	pads := breaks - bytes.Count(prog, []byte{'\n'})
+	if pads < 0 {
+		return nil, ErrCoverTooShort
+	}
+	for i := 0; i < pads; i++ {
+		prog = append(prog, stegoPad...)
+	}
+
+	text := make([]byte, 0, len(cover)*2+len(prog))
+	for i, line := range lines {
+		line, cr := cutCR(line)
+		text = appendCover(text, line)
+		if i == breaks {
+			// the rest after the last newline of the program.
+			text = append(text, prog...)
+			if cr {
+				text = append(text, '\r')
+			}
+			break
+		}
+		n := bytes.IndexByte(prog, '\n')
+		text = append(text, prog[:n]...)
+		if cr {
+			text = append(text, '\r')
+		}
+		text = append(text, '\n')
+		prog = prog[n+1:]
+	}
+	return text, nil
+}
+
+// appendCover appends the cover line replacing the spaces and tabs.
+func appendCover(b, line []byte) []byte {
+	for _, c := range line {
+		switch c {
+		case ' ':
+			b = append(b, stegoSpace...)
+		case '\t':
+			b = append(b, stegoTab...)
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// Extract returns the hidden whitespace program and the cover text from the text made by Embed.
+// The padding after the program is removed.
+// ErrNoHiddenProgram is returned if the whitespace of the text is not a program.
+func Extract(text []byte) (code, cover []byte, err error) {
+	code, err = trimPadding(whitespaces(text))
+	if err != nil || len(code) == 0 {
+		return nil, nil, ErrNoHiddenProgram
+	}
+
+	cover = make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == ' ' || text[i] == '\t':
+		case bytes.HasPrefix(text[i:], []byte(stegoSpace)):
+			cover = append(cover, ' ')
+			i += len(stegoSpace) - 1
+		case bytes.HasPrefix(text[i:], []byte(stegoTab)):
+			cover = append(cover, '\t')
+			i += len(stegoTab) - 1
+		default:
+			cover = append(cover, text[i])
+		}
+	}
+	return code, cover, nil
+}
+
+// trimPadding removes the Push 0 opcodes at the end of the whitespace program.
+func trimPadding(code []byte) ([]byte, error) {
+	vm := New()
+	if _, _, err := vm.Load(code); err != nil {
+		return nil, err
+	}
+	end := len(code)
+	for i := len(vm.Program) - 1; i >= 0; i-- {
+		op := vm.Program[i]
+		if op.Cmd != Push || op.Param != 0 {
+			break
+		}
+		end = op.Pos
+	}
+	return code[:end], nil
+}
+
+// Verify checks that the text made by Embed runs the program of the code.
+// The Push 0 opcodes at the end of the code are not compared, as they are not
+// distinguished from the padding.
+func Verify(text, code []byte) error {
+	code, err := trimPadding(whitespaces(code))
+	if err != nil {
+		return err
+	}
+	orig := New()
+	if _, _, err := orig.Load(code); err != nil {
+		return err
+	}
+	hidden, _, err := Extract(text)
+	if err != nil {
+		return err
+	}
+	vm := New()
+	if _, _, err := vm.Load(hidden); err != nil {
+		return err
+	}
+
+	if len(vm.Program) != len(orig.Program) {
+		return ErrProgramMismatch
+	}
+	for i, op := range orig.Program {
+		if h := vm.Program[i]; h.Cmd != op.Cmd || h.Param != op.Param {
+			return ErrProgramMismatch
+		}
+	}
+	return nil
+}
+
+func cutCR(line []byte) ([]byte, bool) {
+	if l := len(line); l > 0 && line[l-1] == '\r' {
+		return line[:l-1], true
+	}
+	return line, false
+}
+
+func whitespaces(code []byte) []byte {
+	ws := make([]byte, 0, len(code))
+	for _, c := range code {
+		if c == ' ' || c == '\t' || c == '\n' {
+			ws = append(ws, c)
+		}
+	}
+	return ws
+}
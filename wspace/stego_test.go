@@ -0,0 +1,87 @@
+package wspace
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, code []byte) string {
+	t.Helper()
+	vm := New()
+	if _, _, err := vm.Load(code); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var out bytes.Buffer
+	if err := vm.Run(context.Background(), strings.NewReader(""), &out); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return out.String()
+}
+
+func TestEmbed(t *testing.T) {
+	code := "   \t  \t   \n\t\n     \t\t  \t \t\n\t\n     \t\t \t\t  \n \n" +
+		" \t\n  \t\n     \t\t \t\t\t\t\n\t\n     \t    \t\n\t\n  \n\n\n"
+	output := run(t, []byte(code))
+
+	covers := map[string]string{
+		"lines":    strings.Repeat("line\n", 15),
+		"blank":    strings.Repeat("\n", 20),
+		"noeol":    strings.Repeat("a b\tc\n", 15) + "d",
+		"crlf":     strings.Repeat("a b\tc\r\n", 16),
+		"trailing": strings.Repeat("hard break  \nindent\t\n\t\n", 6),
+		"go":       strings.Repeat("package main\n\nfunc main() {\n\tprintln(\"a b\")\n}\n", 3),
+	}
+	for k, cover := range covers {
+		text, err := Embed([]byte(code), []byte(cover))
+		if err != nil {
+			t.Fatalf("%v: Embed: %v", k, err)
+		}
+		if got := strings.Count(string(text), "\n"); got != strings.Count(cover, "\n") {
+			t.Fatalf("%v: lines=%v, wants %v", k, got, strings.Count(cover, "\n"))
+		}
+		if out := run(t, text); out != output {
+			t.Fatalf("%v: output=%q, wants %q", k, out, output)
+		}
+
+		hidden, c, err := Extract(text)
+		if err != nil {
+			t.Fatalf("%v: Extract: %v", k, err)
+		}
+		if string(hidden) != code {
+			t.Fatalf("%v: Extract: code=%q, wants %q", k, hidden, code)
+		}
+		if string(c) != cover {
+			t.Fatalf("%v: Extract: cover=%q, wants %q", k, c, cover)
+		}
+
+		if err := Verify(text, []byte(code)); err != nil {
+			t.Fatalf("%v: Verify: %v", k, err)
+		}
+		if err := Verify(text, []byte("   \t\n\t\n \t\n\n\n")); err != ErrProgramMismatch {
+			t.Fatalf("%v: Verify: %v, wants %v", k, err, ErrProgramMismatch)
+		}
+	}
+}
+
+func TestEmbedError(t *testing.T) {
+	code := []byte("   \t\n\t\n \t\n\n\n")
+	if _, err := Embed(code, []byte("one line\n")); err != ErrCoverTooShort {
+		t.Fatalf("Embed: %v, wants %v", err, ErrCoverTooShort)
+	}
+	if _, err := Embed(code, []byte(strings.Repeat("line\n", 5))); err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	for _, cover := range []string{"a\u00a0b\n\n\n\n\n", "a\u2003b\n\n\n\n\n"} {
+		if _, err := Embed(code, []byte(cover)); err != ErrInvalidCover {
+			t.Fatalf("Embed(%q): %v, wants %v", cover, err, ErrInvalidCover)
+		}
+	}
+
+	for _, text := range []string{"", "plain\n", "plain text\n", "a  \n", "a \t\t\n", "a   \n"} {
+		if _, _, err := Extract([]byte(text)); err != ErrNoHiddenProgram {
+			t.Fatalf("Extract(%q): %v, wants %v", text, err, ErrNoHiddenProgram)
+		}
+	}
+}
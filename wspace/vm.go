@@ -7,9 +7,13 @@ package wspace
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // VM whitespace virtual machine.
@@ -25,8 +29,65 @@ type VM struct {
 	CallStack []int
 
 	Seg int // segment number to be loaded
+
+	Dialect    Dialect   // language version to be loaded
+	Extensions Extension // enabled extensions to be loaded
+	Tracing    bool      // print each executed opcode and the resulting stack to Debug, toggled by Trace
+	Debug      io.Writer // output of the tracing and the extensions; out of Run and Step is used if nil
+
+	Encoding  Encoding  // character encoding of WriteChar and ReadChar
+	EOF       EOFPolicy // behavior of ReadChar and ReadNum at the end of input
+	NumFormat NumFormat // input format of ReadNum
 }
 
+// Dialect is the language version of whitespace.
+type Dialect int
+
+const (
+	Whitespace03 Dialect = iota // Whitespace 0.3 (default)
+	Whitespace02                // Whitespace 0.2: Copy and Slide are not available
+)
+
+// Extension is the set of non-standard commands.
+// They use the instruction sequences unused in any dialect, and are invalid unless enabled.
+type Extension int
+
+const (
+	ExtDebugStack Extension = 1 << iota // DebugStack command
+	ExtDebugHeap                        // DebugHeap command
+	ExtTrace                            // Trace command
+
+	ExtAll = ExtDebugStack | ExtDebugHeap | ExtTrace
+)
+
+// Encoding is the character encoding of WriteChar and ReadChar.
+type Encoding int
+
+const (
+	Bytes  Encoding = iota // a byte as a character (default)
+	UTF8                   // UTF-8 encoded rune
+	Latin1                 // ISO-8859-1: code points above 255 are written as '?'
+)
+
+// EOFPolicy is the behavior of ReadChar and ReadNum at the end of input.
+type EOFPolicy int
+
+const (
+	EOFError    EOFPolicy = iota // stop with ErrEOF (default)
+	EOFMinusOne                  // store -1
+	EOFZero                      // store 0
+)
+
+// NumFormat is the input format of ReadNum.
+// The number is read from a line.
+type NumFormat int
+
+const (
+	NumStrict   NumFormat = iota // decimal with an optional sign (default)
+	NumTolerant                  // NumStrict surrounded by spaces and tabs
+	NumPrefixed                  // NumTolerant also accepting the base prefix: 0b, 0o, 0x
+)
+
 // InputReader is the stdin interface for Step()
 type InputReader interface {
 	io.Reader
@@ -77,6 +138,9 @@ func (vm *VM) Load(code []byte) (int, int, error) {
 			vm.appendOpCode(Dup, pos)
 
 		case " \t ": // Copy
+			if vm.Dialect == Whitespace02 {
+				return vm.Seg, pos, ErrInvalidCode
+			}
 			n, r, err := readNum(code[pos+read:])
 			if err != nil {
 				return vm.Seg, pos, err
@@ -90,6 +154,9 @@ func (vm *VM) Load(code []byte) (int, int, error) {
 			vm.appendOpCode(Discard, pos)
 
 		case " \t\n": // Slide
+			if vm.Dialect == Whitespace02 {
+				return vm.Seg, pos, ErrInvalidCode
+			}
 			n, r, err := readNum(code[pos+read:])
 			if err != nil {
 				return vm.Seg, pos, err
@@ -171,6 +238,22 @@ func (vm *VM) Load(code []byte) (int, int, error) {
 			vm.appendOpCode(Ret, pos)
 		case "\n\n\n": // End
 			vm.appendOpCode(End, pos)
+		case "\n\n ", "\n\n\t": // Extensions
+			c, p := findWhite(code[pos+read:])
+			if p < 0 {
+				return vm.Seg, pos, ErrIncompleteCode
+			}
+			read += p + 1
+			switch {
+			case c3[2] == ' ' && c == ' ' && vm.Extensions&ExtDebugStack != 0:
+				vm.appendOpCode(DebugStack, pos)
+			case c3[2] == ' ' && c == '\t' && vm.Extensions&ExtDebugHeap != 0:
+				vm.appendOpCode(DebugHeap, pos)
+			case c3[2] == '\t' && c == ' ' && vm.Extensions&ExtTrace != 0:
+				vm.appendOpCode(Trace, pos)
+			default:
+				return vm.Seg, pos, ErrInvalidCode
+			}
 		case "\t\n ": // WriteChar, WriteNum
 			c, p := findWhite(code[pos+read:])
 			if p < 0 {
@@ -248,7 +331,17 @@ func (vm *VM) Step(in InputReader, out io.Writer) error {
 		return ErrNotLoaded
 	}
 
-	switch op := vm.Program[vm.PC]; op.Cmd {
+	op := vm.Program[vm.PC]
+	err := vm.step(op, in, out)
+	// traced after the execution to show the resulting stack.
+	if vm.Tracing {
+		fmt.Fprintln(vm.debugWriter(out), op, vm.Stack)
+	}
+	return err
+}
+
+func (vm *VM) step(op OpCode, in InputReader, out io.Writer) error {
+	switch op.Cmd {
 	case Push:
 		vm.Stack = append(vm.Stack, op.Param.(int))
 		vm.PC++
@@ -426,7 +519,7 @@ func (vm *VM) Step(in InputReader, out io.Writer) error {
 			return ErrNotEnoughStack
 		}
 		l := len(vm.Stack)
-		_, err := out.Write([]byte{byte(vm.Stack[l-1])})
+		_, err := out.Write(vm.encodeChar(vm.Stack[l-1]))
 		if err != nil {
 			vm.Terminated = true
 			return err
@@ -451,13 +544,17 @@ func (vm *VM) Step(in InputReader, out io.Writer) error {
 			vm.Terminated = true
 			return ErrNotEnoughStack
 		}
-		c, err := in.ReadByte()
+		l := len(vm.Stack)
+		a := vm.Stack[l-1]
+		c, err := vm.decodeChar(in)
 		if err != nil {
-			vm.Terminated = true
-			return err
+			c, err = vm.inputError(a, err)
+			if err != nil {
+				vm.Terminated = true
+				return err
+			}
 		}
-		l := len(vm.Stack)
-		vm.Heap[vm.Stack[l-1]] = int(c)
+		vm.Heap[a] = c
 		vm.Stack = vm.Stack[:l-1]
 		vm.PC++
 	case ReadNum:
@@ -465,16 +562,28 @@ func (vm *VM) Step(in InputReader, out io.Writer) error {
 			vm.Terminated = true
 			return ErrNotEnoughStack
 		}
-		var n int
-		_, err := fmt.Fscanln(in, &n)
+		l := len(vm.Stack)
+		a := vm.Stack[l-1]
+		n, err := vm.scanNum(in)
 		if err != nil {
-			vm.Terminated = true
-			return err
+			n, err = vm.inputError(a, err)
+			if err != nil {
+				vm.Terminated = true
+				return err
+			}
 		}
-		l := len(vm.Stack)
-		vm.Heap[vm.Stack[l-1]] = n
+		vm.Heap[a] = n
 		vm.Stack = vm.Stack[:l-1]
 		vm.PC++
+	case DebugStack:
+		fmt.Fprintln(vm.debugWriter(out), "stack:", vm.Stack)
+		vm.PC++
+	case DebugHeap:
+		fmt.Fprintln(vm.debugWriter(out), "heap:", vm.Heap)
+		vm.PC++
+	case Trace:
+		vm.Tracing = !vm.Tracing
+		vm.PC++
 
 	default:
 		vm.Terminated = true
@@ -484,6 +593,102 @@ func (vm *VM) Step(in InputReader, out io.Writer) error {
 	return nil
 }
 
+func (vm *VM) debugWriter(out io.Writer) io.Writer {
+	if vm.Debug != nil {
+		return vm.Debug
+	}
+	return out
+}
+
+func (vm *VM) encodeChar(c int) []byte {
+	switch vm.Encoding {
+	case UTF8:
+		if c < 0 || c > utf8.MaxRune {
+			c = utf8.RuneError
+		}
+		return utf8.AppendRune(nil, rune(c))
+	case Latin1:
+		if c < 0 || c > 0xff {
+			c = '?'
+		}
+	}
+	return []byte{byte(c)}
+}
+
+func (vm *VM) decodeChar(in InputReader) (int, error) {
+	if vm.Encoding != UTF8 {
+		c, err := in.ReadByte()
+		return int(c), err
+	}
+	if rr, ok := in.(io.RuneReader); ok {
+		r, _, err := rr.ReadRune()
+		return int(r), err
+	}
+	c, err := in.ReadByte()
+	if err != nil || c < utf8.RuneSelf {
+		return int(c), err
+	}
+	b := []byte{c}
+	for !utf8.FullRune(b) {
+		c, err := in.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		b = append(b, c)
+	}
+	r, _ := utf8.DecodeRune(b)
+	return int(r), nil
+}
+
+func (vm *VM) scanNum(in InputReader) (int, error) {
+	line := make([]byte, 0, 16)
+	for {
+		c, err := in.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				break
+			}
+			return 0, err
+		}
+		if c == '\n' {
+			break
+		}
+		line = append(line, c)
+	}
+
+	s := strings.TrimSuffix(string(line), "\r")
+	base := 10
+	switch vm.NumFormat {
+	case NumTolerant:
+		s = strings.Trim(s, " \t")
+	case NumPrefixed:
+		s = strings.Trim(s, " \t")
+		base = 0
+	}
+	n, err := strconv.ParseInt(s, base, 0)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, ErrOverflow
+		}
+		return 0, fmt.Errorf("%w: %q", ErrInvalidNumber, s)
+	}
+	return int(n), nil
+}
+
+// inputError returns the value to be stored at the address on the input error, following the EOF policy.
+func (vm *VM) inputError(addr int, err error) (int, error) {
+	if errors.Is(err, io.EOF) {
+		switch vm.EOF {
+		case EOFMinusOne:
+			return -1, nil
+		case EOFZero:
+			return 0, nil
+		}
+		err = ErrEOF
+	}
+	return 0, &InputError{Addr: addr, Err: err}
+}
+
 func (vm *VM) appendOpCode(cmd Command, pos int) {
 	vm.Program = append(vm.Program, OpCode{Cmd: cmd, Seg: vm.Seg, Pos: pos})
 }
@@ -2,6 +2,7 @@ package wspace
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -303,3 +304,215 @@ func TestIO(t *testing.T) {
 		t.Fatalf("WriteNum: PC=%v, wants 2", vm.PC)
 	}
 }
+
+func TestEncoding(t *testing.T) {
+	tests := map[string]struct {
+		enc  Encoding
+		in   string
+		char int
+		out  string
+	}{
+		"Bytes":  {Bytes, "あ", 0xe3, "\xe3"},
+		"UTF8":   {UTF8, "あ", 'あ', "あ"},
+		"Latin1": {Latin1, "\xe9", 0xe9, "\xe9"},
+	}
+	for k, test := range tests {
+		vm := New()
+		vm.Encoding = test.enc
+		vm.Program = []OpCode{{Cmd: ReadChar}, {Cmd: Push, Param: 0}, {Cmd: Retrieve}, {Cmd: WriteChar}}
+		vm.Stack = []int{0}
+
+		// use a reader without ReadRune
+		r := struct{ InputReader }{bytes.NewBufferString(test.in)}
+		w := bytes.NewBuffer(nil)
+		for i := 0; i < len(vm.Program); i++ {
+			if err := vm.Step(r, w); err != nil {
+				t.Fatalf("%v: %v: %v", k, vm.Program[i].Cmd, err)
+			}
+		}
+		if v := vm.Heap[0]; v != test.char {
+			t.Fatalf("%v: ReadChar: Heap[0]=%v, wants %v", k, v, test.char)
+		}
+		if s := w.String(); s != test.out {
+			t.Fatalf("%v: WriteChar: out=%q, wants %q", k, s, test.out)
+		}
+	}
+
+	vm := New()
+	vm.Encoding = Latin1
+	vm.Program = []OpCode{{Cmd: WriteChar}}
+	vm.Stack = []int{'あ'}
+	w := bytes.NewBuffer(nil)
+	if err := vm.Step(nil, w); err != nil {
+		t.Fatalf("Latin1: WriteChar: %v", err)
+	}
+	if s := w.String(); s != "?" {
+		t.Fatalf("Latin1: WriteChar: out=%q, wants %q", s, "?")
+	}
+}
+
+func TestEOF(t *testing.T) {
+	tests := map[string]struct {
+		eof    EOFPolicy
+		expect int
+		err    error
+	}{
+		"Error":    {EOFError, 5, ErrEOF},
+		"MinusOne": {EOFMinusOne, -1, nil},
+		"Zero":     {EOFZero, 0, nil},
+	}
+	for k, test := range tests {
+		for _, cmd := range []Command{ReadChar, ReadNum} {
+			vm := New()
+			vm.EOF = test.eof
+			vm.Program = []OpCode{{Cmd: cmd}}
+			vm.Stack = []int{3}
+			vm.Heap[3] = 5
+
+			err := vm.Step(bytes.NewBuffer(nil), nil)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("%v: %v: error=%v, wants %v", k, cmd, err, test.err)
+			}
+			if e := (*InputError)(nil); err != nil && (!errors.As(err, &e) || e.Addr != 3) {
+				t.Fatalf("%v: %v: error=%#v, wants InputError for 3", k, cmd, err)
+			}
+			if v := vm.Heap[3]; v != test.expect {
+				t.Fatalf("%v: %v: Heap[3]=%v, wants %v", k, cmd, v, test.expect)
+			}
+		}
+	}
+}
+
+func TestNumFormat(t *testing.T) {
+	tests := []struct {
+		format NumFormat
+		in     string
+		expect int
+		err    error
+	}{
+		{NumStrict, "-12\n", -12, nil},
+		{NumStrict, "+12", 12, nil},
+		{NumStrict, " 12\n", 0, ErrInvalidNumber},
+		{NumStrict, "0x12\n", 0, ErrInvalidNumber},
+		{NumStrict, "99999999999999999999\n", 0, ErrOverflow},
+		{NumTolerant, "\t 12 \r\n", 12, nil},
+		{NumTolerant, "0x12\n", 0, ErrInvalidNumber},
+		{NumPrefixed, " -0x12\n", -18, nil},
+		{NumPrefixed, "0b101\n", 5, nil},
+		{NumPrefixed, "0o17\n", 15, nil},
+		{NumPrefixed, "12a\n", 0, ErrInvalidNumber},
+	}
+	for _, test := range tests {
+		vm := New()
+		vm.NumFormat = test.format
+		vm.Program = []OpCode{{Cmd: ReadNum}}
+		vm.Stack = []int{7}
+
+		err := vm.Step(bytes.NewBufferString(test.in), nil)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%v %q: error=%v, wants %v", test.format, test.in, err, test.err)
+		}
+		if v := vm.Heap[7]; v != test.expect {
+			t.Fatalf("%v %q: Heap[7]=%v, wants %v", test.format, test.in, v, test.expect)
+		}
+	}
+}
+
+func TestDialect(t *testing.T) {
+	tests := map[string]struct {
+		code string
+		cmd  Command
+	}{
+		"Copy":  {" \t  \t\n", Copy},
+		"Slide": {" \t\n \t\n", Slide},
+	}
+	for k, test := range tests {
+		vm := New()
+		if _, _, err := vm.Load([]byte(test.code)); err != nil {
+			t.Fatalf("%v: default: %v", k, err)
+		}
+		if vm.Program[0].Cmd != test.cmd {
+			t.Fatalf("%v: Program[0]=%v, wants %v", k, vm.Program[0].Cmd, test.cmd)
+		}
+
+		vm = New()
+		vm.Dialect = Whitespace02
+		if _, _, err := vm.Load([]byte(test.code)); err != ErrInvalidCode {
+			t.Fatalf("%v: 0.2: %v", k, err)
+		}
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	tests := map[string]struct {
+		code string
+		ext  Extension
+		cmd  Command
+	}{
+		"DebugStack": {"\n\n  ", ExtDebugStack, DebugStack},
+		"DebugHeap":  {"\n\n \t", ExtDebugHeap, DebugHeap},
+		"Trace":      {"\n\n\t ", ExtTrace, Trace},
+	}
+	for k, test := range tests {
+		vm := New()
+		if _, _, err := vm.Load([]byte(test.code)); err != ErrInvalidCode {
+			t.Fatalf("%v: off: %v", k, err)
+		}
+
+		vm = New()
+		vm.Extensions = ExtAll &^ test.ext
+		if _, _, err := vm.Load([]byte(test.code)); err != ErrInvalidCode {
+			t.Fatalf("%v: others: %v", k, err)
+		}
+
+		vm = New()
+		vm.Extensions = test.ext
+		if _, _, err := vm.Load([]byte(test.code)); err != nil {
+			t.Fatalf("%v: on: %v", k, err)
+		}
+		if vm.Program[0].Cmd != test.cmd {
+			t.Fatalf("%v: Program[0]=%v, wants %v", k, vm.Program[0].Cmd, test.cmd)
+		}
+	}
+
+	vm := New()
+	vm.Extensions = ExtAll
+	if _, _, err := vm.Load([]byte("\n\n\t\t")); err != ErrInvalidCode {
+		t.Fatalf("unused sequence: %v", err)
+	}
+
+	vm = New()
+	vm.Program = []OpCode{{Cmd: DebugStack}, {Cmd: DebugHeap}, {Cmd: Trace}, {Cmd: Push, Param: 3}, {Cmd: Trace}, {Cmd: Push, Param: 4}}
+	vm.Stack = []int{1, 2}
+	vm.Heap[3] = 4
+
+	w := bytes.NewBuffer(nil)
+	for range vm.Program {
+		if err := vm.Step(nil, w); err != nil {
+			t.Fatalf("%v: %v", vm.CurrentOpCode(), err)
+		}
+	}
+	expect := "stack: [1 2]\nheap: map[3:4]\n(0:0) Trace [1 2]\n(0:0) Push 3 [1 2 3]\n"
+	if s := w.String(); s != expect {
+		t.Fatalf("out=%q, wants %q", s, expect)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	vm := New()
+	vm.Program = []OpCode{{Cmd: Push, Param: 1}, {Cmd: Dup}, {Cmd: Add}, {Cmd: Discard}, {Cmd: Discard}}
+	vm.Tracing = true
+
+	w := bytes.NewBuffer(nil)
+	for range vm.Program {
+		if err := vm.Step(nil, w); err != nil {
+			if err != ErrNotEnoughStack {
+				t.Fatalf("%v: %v", vm.CurrentOpCode(), err)
+			}
+		}
+	}
+	expect := "(0:0) Push 1 [1]\n(0:0) Dup [1 1]\n(0:0) Add [2]\n(0:0) Discard []\n(0:0) Discard []\n"
+	if s := w.String(); s != expect {
+		t.Fatalf("out=%q, wants %q", s, expect)
+	}
+}
@@ -0,0 +1,121 @@
+//go:build cgo && interop
+
+// The interoperability tests against libzmq.
+// Run with: go test -tags interop ./zmtp
+
+package zmtp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pebbe/zmq4"
+)
+
+func zmq4Socket(t *testing.T, typ zmq4.Type) *zmq4.Socket {
+	t.Helper()
+	s, err := zmq4.NewSocket(typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLinger(0)
+	s.SetRcvtimeo(3 * time.Second)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestInteropRouter(t *testing.T) {
+	router := bind(t, ROUTER)
+	dealer := zmq4Socket(t, zmq4.DEALER)
+	dealer.SetIdentity("client")
+	if err := dealer.Connect(router.LastEndpoint()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dealer.SendMessage("<IDS|MSG>", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if parts := recv(t, router); !equal(parts, msg("client", "<IDS|MSG>", "hello")) {
+		t.Fatalf("router received %q", parts)
+	}
+	if err := router.Send(msg("client", "<IDS|MSG>", "world")...); err != nil {
+		t.Fatal(err)
+	}
+	parts, err := dealer.RecvMessageBytes(0)
+	if err != nil || !equal(parts, msg("<IDS|MSG>", "world")) {
+		t.Fatalf("dealer received %q: %v", parts, err)
+	}
+}
+
+func TestInteropPub(t *testing.T) {
+	pub := bind(t, PUB)
+	sub := zmq4Socket(t, zmq4.SUB)
+	sub.SetSubscribe("")
+	sub.SetRcvtimeo(10 * time.Millisecond)
+	if err := sub.Connect(pub.LastEndpoint()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscription arrives asynchronously.
+	for i := 0; i < 300; i++ {
+		pub.Send(msg("status", "busy")...)
+		parts, err := sub.RecvMessageBytes(0)
+		if err == nil {
+			if !equal(parts, msg("status", "busy")) {
+				t.Fatalf("sub received %q", parts)
+			}
+			return
+		}
+	}
+	t.Fatalf("not subscribed")
+}
+
+func TestInteropRep(t *testing.T) {
+	rep := bind(t, REP)
+	req := zmq4Socket(t, zmq4.REQ)
+	if err := req.Connect(rep.LastEndpoint()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := req.SendMessage("ping"); err != nil {
+			t.Fatal(err)
+		}
+		parts := recv(t, rep)
+		if err := rep.Send(parts...); err != nil {
+			t.Fatal(err)
+		}
+		parts, err := req.RecvMessageBytes(0)
+		if err != nil || !equal(parts, msg("ping")) {
+			t.Fatalf("req received %q: %v", parts, err)
+		}
+	}
+}
+
+func TestInteropClient(t *testing.T) {
+	router := zmq4Socket(t, zmq4.ROUTER)
+	if err := router.Bind("tcp://127.0.0.1:*"); err != nil {
+		t.Fatal(err)
+	}
+	ep, _ := router.GetLastEndpoint()
+	dealer := NewSocket(DEALER)
+	defer dealer.Close()
+	dealer.SetIdentity([]byte("client"))
+	if err := dealer.Connect(ep); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dealer.Send(msg("hello")...); err != nil {
+		t.Fatal(err)
+	}
+	parts, err := router.RecvMessageBytes(0)
+	if err != nil || !equal(parts, msg("client", "hello")) {
+		t.Fatalf("router received %q: %v", parts, err)
+	}
+	if _, err := router.SendMessage("client", "world"); err != nil {
+		t.Fatal(err)
+	}
+	if parts := recv(t, dealer); !equal(parts, msg("world")) {
+		t.Fatalf("dealer received %q", parts)
+	}
+}
@@ -0,0 +1,578 @@
+package zmtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type is the socket type.
+type Type int
+
+const (
+	PUB Type = iota + 1
+	SUB
+	REQ
+	REP
+	DEALER
+	ROUTER
+)
+
+func (t Type) String() string {
+	switch t {
+	case PUB:
+		return "PUB"
+	case SUB:
+		return "SUB"
+	case REQ:
+		return "REQ"
+	case REP:
+		return "REP"
+	case DEALER:
+		return "DEALER"
+	case ROUTER:
+		return "ROUTER"
+	}
+	return fmt.Sprintf("Type(%d)", int(t))
+}
+
+// compatible reports whether the socket can connect to the peer of the type.
+func (t Type) compatible(peer string) bool {
+	switch t {
+	case PUB:
+		return peer == "SUB" || peer == "XSUB"
+	case SUB:
+		return peer == "PUB" || peer == "XPUB"
+	case REQ:
+		return peer == "REP" || peer == "ROUTER"
+	case REP:
+		return peer == "REQ" || peer == "DEALER"
+	case DEALER:
+		return peer == "REP" || peer == "DEALER" || peer == "ROUTER"
+	case ROUTER:
+		return peer == "REQ" || peer == "DEALER" || peer == "ROUTER"
+	}
+	return false
+}
+
+const (
+	inboxSize  = 1000 // received messages buffered in the socket
+	outboxSize = 1000 // messages buffered for each peer; PUB drops the messages over this
+
+	linger = time.Second // time to send the queued messages on Close
+)
+
+// Socket is a ZeroMQ socket.
+type Socket struct {
+	typ      Type
+	identity []byte
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	peers     []*peer
+	routes    map[string]*peer // ROUTER: identity -> peer
+	nextID    uint32
+	subs      [][]byte // SUB: subscriptions
+	rr        int      // DEALER, REQ: round robin index
+	newPeer   chan struct{}
+
+	inbox  chan incoming
+	peeked *incoming
+
+	// REP: the request to be replied
+	reply    *peer
+	envelope [][]byte
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup // writers of the peers
+}
+
+type incoming struct {
+	peer  *peer
+	parts [][]byte
+}
+
+// peer is a connection to the peer socket.
+type peer struct {
+	conn     net.Conn
+	identity []byte
+	out      chan [][]byte
+	subs     [][]byte // PUB: subscriptions of the peer
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewSocket returns a new socket of the type.
+func NewSocket(typ Type) *Socket {
+	return &Socket{
+		typ:     typ,
+		routes:  make(map[string]*peer),
+		newPeer: make(chan struct{}),
+		inbox:   make(chan incoming, inboxSize),
+		closed:  make(chan struct{}),
+	}
+}
+
+// SetIdentity sets the identity sent to the ROUTER peers. It must be called before Connect.
+func (s *Socket) SetIdentity(id []byte) {
+	s.identity = id
+}
+
+// parseEndpoint returns the network and the address of the endpoint.
+// "tcp://host:port" and "ipc://path" are supported.
+// The host "*" means all the interfaces, and the port "*" means a port chosen by the system.
+func parseEndpoint(endpoint string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(endpoint, "tcp://"):
+		addr := endpoint[len("tcp://"):]
+		if strings.HasPrefix(addr, "*:") {
+			addr = addr[1:]
+		}
+		if strings.HasSuffix(addr, ":*") {
+			addr = addr[:len(addr)-1] + "0"
+		}
+		return "tcp", addr, nil
+	case strings.HasPrefix(endpoint, "ipc://"):
+		return "unix", endpoint[len("ipc://"):], nil
+	}
+	return "", "", fmt.Errorf("%w: %q", ErrEndpoint, endpoint)
+}
+
+// Bind listens on the endpoint and accepts the peers.
+func (s *Socket) Bind(endpoint string) error {
+	network, addr, err := parseEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		l.Close()
+		return ErrClosed
+	default:
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.attach(c)
+		}
+	}()
+	return nil
+}
+
+// LastEndpoint returns the endpoint of the last Bind, which contains the port chosen by the system.
+func (s *Socket) LastEndpoint() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 {
+		return ""
+	}
+	a := s.listeners[len(s.listeners)-1].Addr()
+	if a.Network() == "unix" {
+		return "ipc://" + a.String()
+	}
+	return "tcp://" + a.String()
+}
+
+// Connect connects to the endpoint. The connection is not retried.
+func (s *Socket) Connect(endpoint string) error {
+	network, addr, err := parseEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		return err
+	}
+	return s.attach(c)
+}
+
+// attach does the handshake and starts the peer.
+func (s *Socket) attach(c net.Conn) error {
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	props, err := handshake(r, w, s.typ, s.identity)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	c.SetDeadline(time.Time{})
+
+	p := &peer{
+		conn: c,
+		out:  make(chan [][]byte, outboxSize),
+		done: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		c.Close()
+		return ErrClosed
+	default:
+	}
+	if s.typ == ROUTER {
+		id := props["Identity"]
+		if len(id) == 0 || s.routes[string(id)] != nil {
+			s.nextID++
+			id = binary.BigEndian.AppendUint32([]byte{0}, s.nextID)
+		}
+		p.identity = append([]byte{}, id...)
+		s.routes[string(p.identity)] = p
+	}
+	s.peers = append(s.peers, p)
+	for _, sub := range s.subs {
+		p.out <- [][]byte{append([]byte{1}, sub...)}
+	}
+	close(s.newPeer)
+	s.newPeer = make(chan struct{})
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go s.writeLoop(p, w)
+	go s.readLoop(p, r)
+	return nil
+}
+
+func (s *Socket) detach(p *peer) {
+	p.once.Do(func() {
+		close(p.done)
+		p.conn.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, q := range s.peers {
+			if q == p {
+				s.peers = append(s.peers[:i], s.peers[i+1:]...)
+				break
+			}
+		}
+		if p.identity != nil && s.routes[string(p.identity)] == p {
+			delete(s.routes, string(p.identity))
+		}
+	})
+}
+
+func (s *Socket) writeLoop(p *peer, w *bufio.Writer) {
+	defer s.wg.Done()
+	defer s.detach(p)
+	for {
+		select {
+		case parts := <-p.out:
+			if err := writeMessage(w, parts); err != nil {
+				return
+			}
+			if len(p.out) == 0 {
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		case <-p.done:
+			return
+		case <-s.closed:
+			// send the queued messages before closing the connection.
+			p.conn.SetWriteDeadline(time.Now().Add(linger))
+			for {
+				select {
+				case parts := <-p.out:
+					if err := writeMessage(w, parts); err != nil {
+						return
+					}
+				default:
+					w.Flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Socket) readLoop(p *peer, r *bufio.Reader) {
+	defer s.detach(p)
+	var parts [][]byte
+	for {
+		flags, body, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if flags&flagCommand != 0 {
+			s.handleCommand(p, body)
+			continue
+		}
+		parts = append(parts, body)
+		if flags&flagMore != 0 {
+			continue
+		}
+		msg := parts
+		parts = nil
+
+		if s.typ == PUB {
+			// the messages from SUB are the subscriptions.
+			if len(msg) == 1 && len(msg[0]) > 0 {
+				s.subscribe(p, msg[0][0] == 1, msg[0][1:])
+			}
+			continue
+		}
+		select {
+		case s.inbox <- incoming{p, msg}:
+		case <-p.done:
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *Socket) handleCommand(p *peer, body []byte) {
+	cmd, err := parseCommand(body)
+	if err != nil {
+		return
+	}
+	switch cmd.name {
+	case "SUBSCRIBE", "CANCEL": // ZMTP 3.1
+		if s.typ == PUB {
+			s.subscribe(p, cmd.name == "SUBSCRIBE", cmd.data)
+		}
+	}
+}
+
+func (s *Socket) subscribe(p *peer, on bool, topic []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, t := range p.subs {
+		if bytes.Equal(t, topic) {
+			if !on {
+				p.subs = append(p.subs[:i], p.subs[i+1:]...)
+			}
+			return
+		}
+	}
+	if on {
+		p.subs = append(p.subs, append([]byte{}, topic...))
+	}
+}
+
+// SetSubscribe subscribes the topic. Only for SUB.
+func (s *Socket) SetSubscribe(topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs = append(s.subs, []byte(topic))
+	for _, p := range s.peers {
+		select {
+		case p.out <- [][]byte{append([]byte{1}, topic...)}:
+		default:
+		}
+	}
+}
+
+// Poll waits for a message to be received until the timeout, and reports whether it is ready.
+func (s *Socket) Poll(timeout time.Duration) (bool, error) {
+	select {
+	case <-s.closed:
+		return false, ErrClosed
+	default:
+	}
+	s.mu.Lock()
+	ready := s.peeked != nil
+	s.mu.Unlock()
+	if ready {
+		return true, nil
+	}
+
+	// a received message takes precedence over the expired timer.
+	select {
+	case in := <-s.inbox:
+		s.peek(in)
+		return true, nil
+	default:
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case in := <-s.inbox:
+		s.peek(in)
+		return true, nil
+	case <-t.C:
+		return false, nil
+	case <-s.closed:
+		return false, ErrClosed
+	}
+}
+
+func (s *Socket) peek(in incoming) {
+	s.mu.Lock()
+	s.peeked = &in
+	s.mu.Unlock()
+}
+
+// Recv receives a multipart message.
+// ROUTER prepends the identity of the peer, and REP and REQ strip the envelope.
+func (s *Socket) Recv() ([][]byte, error) {
+	for {
+		s.mu.Lock()
+		in := s.peeked
+		s.peeked = nil
+		s.mu.Unlock()
+		if in == nil {
+			select {
+			case i := <-s.inbox:
+				in = &i
+			case <-s.closed:
+				return nil, ErrClosed
+			}
+		}
+
+		switch s.typ {
+		case ROUTER:
+			return append([][]byte{in.peer.identity}, in.parts...), nil
+		case REP:
+			for i, p := range in.parts {
+				if len(p) == 0 {
+					s.reply = in.peer
+					s.envelope = in.parts[:i+1]
+					return in.parts[i+1:], nil
+				}
+			}
+			// drop the message without the envelope
+		case REQ:
+			if len(in.parts) > 0 && len(in.parts[0]) == 0 {
+				return in.parts[1:], nil
+			}
+		default:
+			return in.parts, nil
+		}
+	}
+}
+
+// Send sends a multipart message.
+// ROUTER routes the message by the first part, and the message to an unknown peer is dropped.
+// PUB drops the message if the peer is too slow.
+func (s *Socket) Send(parts ...[]byte) error {
+	select {
+	case <-s.closed:
+		return ErrClosed
+	default:
+	}
+
+	switch s.typ {
+	case ROUTER:
+		if len(parts) == 0 {
+			return nil
+		}
+		s.mu.Lock()
+		p := s.routes[string(parts[0])]
+		s.mu.Unlock()
+		if p == nil {
+			return nil
+		}
+		return s.enqueue(p, parts[1:])
+	case PUB:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, p := range s.peers {
+			if subscribed(p.subs, parts) {
+				select {
+				case p.out <- parts:
+				default:
+				}
+			}
+		}
+		return nil
+	case REP:
+		p, env := s.reply, s.envelope
+		if p == nil {
+			return ErrNoReply
+		}
+		s.reply, s.envelope = nil, nil
+		return s.enqueue(p, append(append([][]byte{}, env...), parts...))
+	case REQ:
+		parts = append([][]byte{{}}, parts...)
+		fallthrough
+	case DEALER:
+		p, err := s.nextPeer()
+		if err != nil {
+			return err
+		}
+		return s.enqueue(p, parts)
+	}
+	return fmt.Errorf("zmtp: %v can not send", s.typ)
+}
+
+func subscribed(subs [][]byte, parts [][]byte) bool {
+	var topic []byte
+	if len(parts) > 0 {
+		topic = parts[0]
+	}
+	for _, sub := range subs {
+		if bytes.HasPrefix(topic, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Socket) enqueue(p *peer, parts [][]byte) error {
+	select {
+	case p.out <- parts:
+		return nil
+	case <-p.done:
+		return nil // the peer is gone
+	case <-s.closed:
+		return ErrClosed
+	}
+}
+
+// nextPeer returns the peer in round robin, waiting for a peer to be connected.
+func (s *Socket) nextPeer() (*peer, error) {
+	for {
+		s.mu.Lock()
+		if n := len(s.peers); n > 0 {
+			s.rr = (s.rr + 1) % n
+			p := s.peers[s.rr]
+			s.mu.Unlock()
+			return p, nil
+		}
+		wait := s.newPeer
+		s.mu.Unlock()
+		select {
+		case <-wait:
+		case <-s.closed:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// Close closes the listeners and the connections after sending the queued messages.
+// The blocked Recv and Poll return ErrClosed.
+func (s *Socket) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		close(s.closed)
+		ls := s.listeners
+		s.mu.Unlock()
+		for _, l := range ls {
+			l.Close()
+		}
+		s.wg.Wait()
+	})
+	return nil
+}
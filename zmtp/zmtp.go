@@ -0,0 +1,218 @@
+// zmtp package implements the ZeroMQ sockets over [ZMTP 3.0] in pure Go.
+//
+// Only the NULL security mechanism is supported, and the socket types are limited to
+// the ones used by the Jupyter kernel (ROUTER, PUB, REP) and their peers (DEALER, SUB, REQ).
+//
+// [ZMTP 3.0]: https://rfc.zeromq.org/spec/23/
+package zmtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+
+	greetingSize = 64
+	maxFrameSize = 1 << 28
+)
+
+var (
+	ErrClosed    = errors.New("zmtp: socket closed")
+	ErrHandshake = errors.New("zmtp: handshake failed")
+	ErrFrameSize = errors.New("zmtp: frame too large")
+	ErrNoReply   = errors.New("zmtp: no request to reply")
+	ErrEndpoint  = errors.New("zmtp: invalid endpoint")
+)
+
+// greeting returns the greeting of ZMTP 3.0 with the NULL mechanism.
+func greeting() []byte {
+	g := make([]byte, greetingSize)
+	g[0] = 0xff
+	g[9] = 0x7f
+	g[10] = 3 // major version
+	g[11] = 0 // minor version
+	copy(g[12:32], "NULL")
+	return g
+}
+
+// readGreeting reads the greeting of the peer and checks the version and the mechanism.
+func readGreeting(r io.Reader) error {
+	g := make([]byte, greetingSize)
+	if _, err := io.ReadFull(r, g); err != nil {
+		return err
+	}
+	if g[0] != 0xff || g[9]&0x01 == 0 {
+		return fmt.Errorf("%w: invalid signature", ErrHandshake)
+	}
+	if g[10] < 3 {
+		return fmt.Errorf("%w: unsupported version %d.%d", ErrHandshake, g[10], g[11])
+	}
+	if mech := string(bytes.TrimRight(g[12:32], "\x00")); mech != "NULL" {
+		return fmt.Errorf("%w: unsupported mechanism %q", ErrHandshake, mech)
+	}
+	return nil
+}
+
+func writeFrame(w *bufio.Writer, flags byte, body []byte) error {
+	if len(body) > 255 {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(body)))
+		w.WriteByte(flags | flagLong)
+		w.Write(size[:])
+	} else {
+		w.WriteByte(flags)
+		w.WriteByte(byte(len(body)))
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// writeMessage writes the message as the frames.
+func writeMessage(w *bufio.Writer, parts [][]byte) error {
+	for i, p := range parts {
+		var flags byte
+		if i < len(parts)-1 {
+			flags = flagMore
+		}
+		if err := writeFrame(w, flags, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFrame reads a frame and returns the flags and the body.
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&flagLong != 0 {
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(b[:])
+	} else {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+	if size > maxFrameSize {
+		return 0, nil, ErrFrameSize
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+// command is a command frame.
+type command struct {
+	name string
+	data []byte
+}
+
+func parseCommand(body []byte) (*command, error) {
+	if len(body) < 1 || len(body) < 1+int(body[0]) {
+		return nil, fmt.Errorf("%w: invalid command", ErrHandshake)
+	}
+	n := int(body[0])
+	return &command{name: string(body[1 : 1+n]), data: body[1+n:]}, nil
+}
+
+func commandBody(name string, data []byte) []byte {
+	b := make([]byte, 0, 1+len(name)+len(data))
+	b = append(b, byte(len(name)))
+	b = append(b, name...)
+	return append(b, data...)
+}
+
+// readyBody returns the body of the READY command with the properties.
+func readyBody(props map[string][]byte) []byte {
+	var data []byte
+	for _, k := range []string{"Socket-Type", "Identity"} {
+		v, ok := props[k]
+		if !ok {
+			continue
+		}
+		data = append(data, byte(len(k)))
+		data = append(data, k...)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(v)))
+		data = append(data, v...)
+	}
+	return commandBody("READY", data)
+}
+
+func parseProperties(data []byte) (map[string][]byte, error) {
+	props := make(map[string][]byte)
+	for len(data) > 0 {
+		n := int(data[0])
+		if len(data) < 1+n+4 {
+			return nil, fmt.Errorf("%w: invalid property", ErrHandshake)
+		}
+		name := string(data[1 : 1+n])
+		data = data[1+n:]
+		m := int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		if len(data) < m {
+			return nil, fmt.Errorf("%w: invalid property", ErrHandshake)
+		}
+		props[name] = data[:m]
+		data = data[m:]
+	}
+	return props, nil
+}
+
+// handshake exchanges the greetings and the READY commands, and returns the properties of the peer.
+func handshake(r *bufio.Reader, w *bufio.Writer, typ Type, identity []byte) (map[string][]byte, error) {
+	w.Write(greeting())
+	props := map[string][]byte{"Socket-Type": []byte(typ.String())}
+	if identity != nil {
+		props["Identity"] = identity
+	}
+	if err := writeFrame(w, flagCommand, readyBody(props)); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	if err := readGreeting(r); err != nil {
+		return nil, err
+	}
+	flags, body, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagCommand == 0 {
+		return nil, fmt.Errorf("%w: READY is expected", ErrHandshake)
+	}
+	cmd, err := parseCommand(body)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.name != "READY" {
+		return nil, fmt.Errorf("%w: %v", ErrHandshake, cmd.name)
+	}
+	peer, err := parseProperties(cmd.data)
+	if err != nil {
+		return nil, err
+	}
+	if !typ.compatible(string(peer["Socket-Type"])) {
+		return nil, fmt.Errorf("%w: %v can not connect to %s", ErrHandshake, typ, peer["Socket-Type"])
+	}
+	return peer, nil
+}
@@ -0,0 +1,179 @@
+package zmtp
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func bind(t *testing.T, typ Type) *Socket {
+	t.Helper()
+	s := NewSocket(typ)
+	if err := s.Bind("tcp://127.0.0.1:*"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func connect(t *testing.T, typ Type, endpoint string) *Socket {
+	t.Helper()
+	s := NewSocket(typ)
+	if err := s.Connect(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func recv(t *testing.T, s *Socket) [][]byte {
+	t.Helper()
+	ok, err := s.Poll(3 * time.Second)
+	if err != nil || !ok {
+		t.Fatalf("Poll: %v %v", ok, err)
+	}
+	parts, err := s.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parts
+}
+
+func equal(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func msg(ss ...string) [][]byte {
+	parts := make([][]byte, len(ss))
+	for i, s := range ss {
+		parts[i] = []byte(s)
+	}
+	return parts
+}
+
+func TestRouterDealer(t *testing.T) {
+	router := bind(t, ROUTER)
+	dealer := NewSocket(DEALER)
+	dealer.SetIdentity([]byte("client"))
+	if err := dealer.Connect(router.LastEndpoint()); err != nil {
+		t.Fatal(err)
+	}
+	defer dealer.Close()
+
+	long := bytes.Repeat([]byte("x"), 1000)
+	if err := dealer.Send([]byte("hello"), long); err != nil {
+		t.Fatal(err)
+	}
+	if parts := recv(t, router); !equal(parts, [][]byte{[]byte("client"), []byte("hello"), long}) {
+		t.Fatalf("router received %q", parts)
+	}
+
+	if err := router.Send(msg("client", "world")...); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Send(msg("unknown", "dropped")...); err != nil {
+		t.Fatal(err)
+	}
+	if parts := recv(t, dealer); !equal(parts, msg("world")) {
+		t.Fatalf("dealer received %q", parts)
+	}
+	if ok, _ := dealer.Poll(100 * time.Millisecond); ok {
+		t.Fatalf("message to the unknown peer is received")
+	}
+}
+
+func TestRepReq(t *testing.T) {
+	rep := bind(t, REP)
+	req := connect(t, REQ, rep.LastEndpoint())
+
+	if err := rep.Send(msg("x")...); err != ErrNoReply {
+		t.Fatalf("Send before Recv: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := req.Send(msg("ping")...); err != nil {
+			t.Fatal(err)
+		}
+		parts := recv(t, rep)
+		if !equal(parts, msg("ping")) {
+			t.Fatalf("rep received %q", parts)
+		}
+		if err := rep.Send(parts...); err != nil {
+			t.Fatal(err)
+		}
+		if parts := recv(t, req); !equal(parts, msg("ping")) {
+			t.Fatalf("req received %q", parts)
+		}
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	pub := bind(t, PUB)
+	sub := connect(t, SUB, pub.LastEndpoint())
+	sub.SetSubscribe("a")
+
+	// the subscription arrives asynchronously.
+	for i := 0; ; i++ {
+		if i == 100 {
+			t.Fatalf("not subscribed")
+		}
+		pub.Send(msg("b", "ignored")...)
+		pub.Send(msg("ab", "1")...)
+		if ok, _ := sub.Poll(10 * time.Millisecond); ok {
+			break
+		}
+	}
+	if parts := recv(t, sub); !equal(parts, msg("ab", "1")) {
+		t.Fatalf("sub received %q", parts)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := NewSocket(ROUTER)
+	path := filepath.Join(t.TempDir(), "sock")
+	if err := s.Bind("ipc://" + path); err != nil {
+		t.Fatal(err)
+	}
+	if ep := s.LastEndpoint(); ep != "ipc://"+path {
+		t.Fatalf("LastEndpoint=%q", ep)
+	}
+	if err := s.Bind("udp://127.0.0.1:0"); err == nil {
+		t.Fatalf("no error for the invalid endpoint")
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := s.Recv()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+	select {
+	case err := <-done:
+		if err != ErrClosed {
+			t.Fatalf("Recv: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Recv is not unblocked")
+	}
+	if _, err := s.Poll(0); err != ErrClosed {
+		t.Fatalf("Poll: %v", err)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	pub := bind(t, PUB)
+	dealer := NewSocket(DEALER)
+	defer dealer.Close()
+	if err := dealer.Connect(pub.LastEndpoint()); err == nil {
+		t.Fatalf("DEALER connected to PUB")
+	}
+}